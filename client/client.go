@@ -7,6 +7,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/ThinkontrolSY/flux-builder/literal"
 	"github.com/ThinkontrolSY/flux-builder/query"
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
 	"github.com/influxdata/influxdb-client-go/v2/api"
@@ -20,11 +21,8 @@ type Config struct {
 	Org   string `mapstructure:"org"`
 }
 
-type MeasurementSchema struct {
-	Measurement string   `json:"measurement"`
-	Fields      []string `json:"fields"`
-	Tags        []string `json:"tags"`
-}
+// MeasurementSchema is the input of query.FluxQuery.Analyze.
+type MeasurementSchema = query.MeasurementSchema
 
 type InfluxClient struct {
 	client influxdb2.Client
@@ -107,25 +105,25 @@ func (w *InfluxClient) Schema(ctx context.Context, bucket string) ([]*Measuremen
 	var schema []*MeasurementSchema
 	queryAPI := w.client.QueryAPI(w.org)
 	result, err := queryAPI.Query(ctx, fmt.Sprintf(`import "influxdata/influxdb/schema"
-	schema.measurements(bucket: "%s")`, bucket))
+	schema.measurements(bucket: %s)`, literal.String(bucket)))
 	if err != nil {
-		log.Warn("query error: %v", err)
+		log.Warnf("query error: %v", err)
 		return nil, err
 	}
 	if result.Err() != nil {
-		log.Warn("query parsing error: %s", result.Err().Error())
+		log.Warnf("query parsing error: %s", result.Err().Error())
 		return nil, result.Err()
 	}
 	for result.Next() {
 		measurement := fmt.Sprintf("%s", result.Record().Value())
 		fieldResult, fieldErr := queryAPI.Query(ctx, fmt.Sprintf(`import "influxdata/influxdb/schema"
-		schema.measurementFieldKeys(bucket: "%s", measurement: "%s",)`, bucket, measurement))
+		schema.measurementFieldKeys(bucket: %s, measurement: %s,)`, literal.String(bucket), literal.String(measurement)))
 		if fieldErr != nil {
-			log.Warn("query error: %v", fieldErr)
+			log.Warnf("query error: %v", fieldErr)
 			return nil, fieldErr
 		}
 		if fieldResult.Err() != nil {
-			log.Warn("query parsing error: %s", fieldResult.Err().Error())
+			log.Warnf("query parsing error: %s", fieldResult.Err().Error())
 			return nil, fieldResult.Err()
 		}
 		var fields []string
@@ -133,13 +131,13 @@ func (w *InfluxClient) Schema(ctx context.Context, bucket string) ([]*Measuremen
 			fields = append(fields, fmt.Sprintf("%s", fieldResult.Record().Value()))
 		}
 		tagResult, tagErr := queryAPI.Query(ctx, fmt.Sprintf(`import "influxdata/influxdb/schema"
-		schema.measurementTagKeys(bucket: "%s", measurement: "%s",)`, bucket, measurement))
+		schema.measurementTagKeys(bucket: %s, measurement: %s,)`, literal.String(bucket), literal.String(measurement)))
 		if tagErr != nil {
-			log.Warn("query error: %v", tagErr)
+			log.Warnf("query error: %v", tagErr)
 			return nil, tagErr
 		}
 		if tagResult.Err() != nil {
-			log.Warn("query parsing error: %s", tagResult.Err().Error())
+			log.Warnf("query parsing error: %s", tagResult.Err().Error())
 			return nil, tagResult.Err()
 		}
 		var tags []string
@@ -164,16 +162,16 @@ func (w *InfluxClient) TagValues(ctx context.Context, bucket, measurement, tag s
 	queryAPI := w.client.QueryAPI(w.org)
 	result, err := queryAPI.Query(ctx, fmt.Sprintf(`import "influxdata/influxdb/schema"
 	schema.measurementTagValues(
-		bucket: "%s",
-		tag: "%s",
-		measurement: "%s",
-	)`, bucket, tag, measurement))
+		bucket: %s,
+		tag: %s,
+		measurement: %s,
+	)`, literal.String(bucket), literal.String(tag), literal.String(measurement)))
 	if err != nil {
-		log.Warn("query error: %v", err)
+		log.Warnf("query error: %v", err)
 		return nil, err
 	}
 	if result.Err() != nil {
-		log.Warn("query parsing error: %s", result.Err().Error())
+		log.Warnf("query parsing error: %s", result.Err().Error())
 		return nil, result.Err()
 	}
 	for result.Next() {
@@ -182,13 +180,22 @@ func (w *InfluxClient) TagValues(ctx context.Context, bucket, measurement, tag s
 	return tags, nil
 }
 
+// queryParams converts the params of a built query into the params object of
+// the query request, omitting it when nothing is bound.
+func queryParams(params map[string]interface{}) interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
 func (w *InfluxClient) Query(ctx context.Context, q query.FluxQuery) ([]*iq.FluxRecord, error) {
-	flux, err := q.QueryString()
+	flux, params, err := q.Build()
 	if err != nil {
 		return nil, err
 	}
 	queryAPI := w.client.QueryAPI(w.org)
-	result, err := queryAPI.Query(ctx, flux)
+	result, err := queryAPI.QueryWithParams(ctx, flux, queryParams(params))
 	if err != nil {
 		return nil, err
 	}
@@ -224,19 +231,25 @@ func (w *InfluxClient) Query(ctx context.Context, q query.FluxQuery) ([]*iq.Flux
 }
 
 func (w *InfluxClient) StrQuery(ctx context.Context, q string) ([]*iq.FluxRecord, error) {
+	return w.StrQueryWithParams(ctx, q, nil)
+}
+
+// StrQueryWithParams runs a script that references params.name, e.g. the
+// output of JoinQuery.Build or UnionQuery.Build.
+func (w *InfluxClient) StrQueryWithParams(ctx context.Context, q string, params map[string]interface{}) ([]*iq.FluxRecord, error) {
 	queryAPI := w.client.QueryAPI(w.org)
-	result, err := queryAPI.Query(ctx, q)
+	result, err := queryAPI.QueryWithParams(ctx, q, queryParams(params))
 	if err != nil {
 		return nil, err
 	}
 	var tables []*iq.FluxRecord
 	// check for an error
 	if result.Err() != nil {
-		log.Warn("query parsing error: %s", result.Err().Error())
+		log.Warnf("query parsing error: %s", result.Err().Error())
 	}
 	for result.Next() {
 		if result.TableChanged() {
-			log.Warn("table: %s", result.TableMetadata().String())
+			log.Warnf("table: %s", result.TableMetadata().String())
 		}
 		tables = append(tables, result.Record())
 	}
@@ -244,12 +257,12 @@ func (w *InfluxClient) StrQuery(ctx context.Context, q string) ([]*iq.FluxRecord
 }
 
 func (w *InfluxClient) QueryRaw(ctx context.Context, q query.FluxQuery) (string, error) {
-	flux, err := q.QueryString()
+	flux, params, err := q.Build()
 	if err != nil {
 		return "", err
 	}
 	queryAPI := w.client.QueryAPI(w.org)
-	result, err := queryAPI.QueryRaw(ctx, flux, influxdb2.DefaultDialect())
+	result, err := queryAPI.QueryRawWithParams(ctx, flux, influxdb2.DefaultDialect(), queryParams(params))
 	if err != nil {
 		return "", err
 	}
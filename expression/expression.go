@@ -0,0 +1,496 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	lit "github.com/ThinkontrolSY/flux-builder/literal"
+)
+
+// Expr is a Flux expression that can be composed in Go and rendered into
+// valid Flux source.
+type Expr interface {
+	Expr() (string, error)
+}
+
+type Operator string
+
+const (
+	OpEq       Operator = "=="
+	OpNEQ      Operator = "!="
+	OpLT       Operator = "<"
+	OpLTE      Operator = "<="
+	OpGT       Operator = ">"
+	OpGTE      Operator = ">="
+	OpMatch    Operator = "=~"
+	OpNMatch   Operator = "!~"
+	OpAdd      Operator = "+"
+	OpSub      Operator = "-"
+	OpMul      Operator = "*"
+	OpDiv      Operator = "/"
+	OpMod      Operator = "%"
+	OpPow      Operator = "^"
+	OpAnd      Operator = "and"
+	OpOr       Operator = "or"
+	OpNot      Operator = "not"
+	OpExists   Operator = "exists"
+	OpNegative Operator = "-"
+)
+
+func validIdentifier(name string) error {
+	return lit.Identifier(name)
+}
+
+// ColumnRef references a column of the record bound to Param, e.g. r._value
+// or r["my-tag"].
+type ColumnRef struct {
+	Param string
+	Name  string
+}
+
+// Col references a column of the default record parameter r.
+func Col(name string) *ColumnRef {
+	return &ColumnRef{Param: "r", Name: name}
+}
+
+// Column references a column of the record bound to param, e.g. l._value in
+// a join lambda.
+func Column(param, name string) *ColumnRef {
+	return &ColumnRef{Param: param, Name: name}
+}
+
+// Param references a value sent in the params object of the query request,
+// e.g. params.threshold.
+func Param(name string) *ColumnRef {
+	return &ColumnRef{Param: "params", Name: name}
+}
+
+func (c *ColumnRef) Expr() (string, error) {
+	if err := validIdentifier(c.Param); err != nil {
+		return "", err
+	}
+	if c.Name == "" {
+		return "", fmt.Errorf("empty column name")
+	}
+	return lit.Member(c.Param, c.Name), nil
+}
+
+// Ident references a variable or a package member, e.g. v.timeRangeStart.
+type Ident string
+
+func (i Ident) Expr() (string, error) {
+	for _, part := range strings.Split(string(i), ".") {
+		if err := validIdentifier(part); err != nil {
+			return "", err
+		}
+	}
+	return string(i), nil
+}
+
+// Raw is Flux source inserted verbatim. It is the escape hatch for constructs
+// the package does not model and is not checked in any way.
+type Raw string
+
+func (r Raw) Expr() (string, error) {
+	if strings.TrimSpace(string(r)) == "" {
+		return "", fmt.Errorf("empty raw expression")
+	}
+	return string(r), nil
+}
+
+type literal struct {
+	value string
+	err   error
+}
+
+func (l *literal) Expr() (string, error) {
+	return l.value, l.err
+}
+
+func String(s string) Expr {
+	return &literal{value: lit.String(s)}
+}
+
+func Int(i int64) Expr {
+	return &literal{value: lit.Int(i)}
+}
+
+func UInt(u uint64) Expr {
+	return &literal{value: lit.UInt(u)}
+}
+
+func Float(f float64) Expr {
+	s, err := lit.Float(f)
+	return &literal{value: s, err: err}
+}
+
+func Bool(b bool) Expr {
+	return &literal{value: lit.Bool(b)}
+}
+
+func Duration(d string) Expr {
+	s, err := lit.Duration(d)
+	return &literal{value: s, err: err}
+}
+
+func Time(t time.Time) Expr {
+	return &literal{value: lit.Time(t)}
+}
+
+func Regex(pattern string) Expr {
+	s, err := lit.Regex(pattern)
+	return &literal{value: s, err: err}
+}
+
+func Array(elements ...Expr) Expr {
+	return &array{elements: elements}
+}
+
+type array struct {
+	elements []Expr
+}
+
+func (a *array) Expr() (string, error) {
+	elements := make([]string, 0, len(a.elements))
+	for _, e := range a.elements {
+		s, err := render(e)
+		if err != nil {
+			return "", err
+		}
+		elements = append(elements, s)
+	}
+	return fmt.Sprintf("[%s]", strings.Join(elements, ", ")), nil
+}
+
+// Binary is an infix comparison, arithmetic or logical operation.
+type Binary struct {
+	Op    Operator
+	Left  Expr
+	Right Expr
+}
+
+func (b *Binary) Expr() (string, error) {
+	switch b.Op {
+	case OpEq, OpNEQ, OpLT, OpLTE, OpGT, OpGTE, OpMatch, OpNMatch,
+		OpAdd, OpSub, OpMul, OpDiv, OpMod, OpPow, OpAnd, OpOr:
+	default:
+		return "", fmt.Errorf("invalid binary operator: %s", b.Op)
+	}
+	p := precedence(b)
+	left, right := p, p+1
+	switch {
+	case p == 4:
+		// comparisons do not chain
+		left++
+	case b.Op == OpPow:
+		// ^ is right associative
+		left, right = p+1, p
+	}
+	l, err := operand(b.Left, left)
+	if err != nil {
+		return "", err
+	}
+	r, err := operand(b.Right, right)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s", l, b.Op, r), nil
+}
+
+func Eq(l, r Expr) *Binary     { return &Binary{Op: OpEq, Left: l, Right: r} }
+func NEQ(l, r Expr) *Binary    { return &Binary{Op: OpNEQ, Left: l, Right: r} }
+func LT(l, r Expr) *Binary     { return &Binary{Op: OpLT, Left: l, Right: r} }
+func LTE(l, r Expr) *Binary    { return &Binary{Op: OpLTE, Left: l, Right: r} }
+func GT(l, r Expr) *Binary     { return &Binary{Op: OpGT, Left: l, Right: r} }
+func GTE(l, r Expr) *Binary    { return &Binary{Op: OpGTE, Left: l, Right: r} }
+func Match(l, r Expr) *Binary  { return &Binary{Op: OpMatch, Left: l, Right: r} }
+func NMatch(l, r Expr) *Binary { return &Binary{Op: OpNMatch, Left: l, Right: r} }
+func Add(l, r Expr) *Binary    { return &Binary{Op: OpAdd, Left: l, Right: r} }
+func Sub(l, r Expr) *Binary    { return &Binary{Op: OpSub, Left: l, Right: r} }
+func Mul(l, r Expr) *Binary    { return &Binary{Op: OpMul, Left: l, Right: r} }
+func Div(l, r Expr) *Binary    { return &Binary{Op: OpDiv, Left: l, Right: r} }
+func Mod(l, r Expr) *Binary    { return &Binary{Op: OpMod, Left: l, Right: r} }
+func Pow(l, r Expr) *Binary    { return &Binary{Op: OpPow, Left: l, Right: r} }
+
+// Logical joins its operands with and/or.
+type Logical struct {
+	Op       Operator
+	Operands []Expr
+}
+
+func (l *Logical) Expr() (string, error) {
+	if l.Op != OpAnd && l.Op != OpOr {
+		return "", fmt.Errorf("invalid logical operator: %s", l.Op)
+	}
+	switch len(l.Operands) {
+	case 0:
+		return "", fmt.Errorf("empty %s expression", l.Op)
+	case 1:
+		return render(l.Operands[0])
+	}
+	operands := make([]string, 0, len(l.Operands))
+	for _, o := range l.Operands {
+		s, err := operand(o, precedence(l))
+		if err != nil {
+			return "", err
+		}
+		operands = append(operands, s)
+	}
+	return strings.Join(operands, fmt.Sprintf(" %s ", l.Op)), nil
+}
+
+func And(operands ...Expr) *Logical { return &Logical{Op: OpAnd, Operands: operands} }
+func Or(operands ...Expr) *Logical  { return &Logical{Op: OpOr, Operands: operands} }
+
+// Unary is a prefix operation: not, exists or arithmetic negation.
+type Unary struct {
+	Op      Operator
+	Operand Expr
+}
+
+func (u *Unary) Expr() (string, error) {
+	o, err := operand(u.Operand, precedence(u))
+	if err != nil {
+		return "", err
+	}
+	switch u.Op {
+	case OpNot, OpExists:
+		return fmt.Sprintf("%s %s", u.Op, o), nil
+	case OpNegative:
+		return fmt.Sprintf("-%s", o), nil
+	default:
+		return "", fmt.Errorf("invalid unary operator: %s", u.Op)
+	}
+}
+
+func Not(e Expr) *Unary    { return &Unary{Op: OpNot, Operand: e} }
+func Exists(e Expr) *Unary { return &Unary{Op: OpExists, Operand: e} }
+func Neg(e Expr) *Unary    { return &Unary{Op: OpNegative, Operand: e} }
+
+// Arg is a named argument of a function call.
+type Arg struct {
+	Name  string
+	Value Expr
+}
+
+func Named(name string, value Expr) Arg {
+	return Arg{Name: name, Value: value}
+}
+
+// CallExpr calls a function, optionally qualified by its package, with named
+// arguments: math.abs(x: r._value). Package overrides the import path derived
+// from the qualifier.
+type CallExpr struct {
+	Fn      string
+	Args    []Arg
+	Package string
+}
+
+func Call(fn string, args ...Arg) *CallExpr {
+	return &CallExpr{Fn: fn, Args: args}
+}
+
+func (c *CallExpr) Expr() (string, error) {
+	fn, err := Ident(c.Fn).Expr()
+	if err != nil {
+		return "", err
+	}
+	args := make([]string, 0, len(c.Args))
+	for _, a := range c.Args {
+		if err := validIdentifier(a.Name); err != nil {
+			return "", err
+		}
+		v, err := render(a.Value)
+		if err != nil {
+			return "", err
+		}
+		args = append(args, fmt.Sprintf("%s: %s", a.Name, v))
+	}
+	return fmt.Sprintf("%s(%s)", fn, strings.Join(args, ", ")), nil
+}
+
+// Conditional is if Test then Consequent else Alternate.
+type Conditional struct {
+	Test       Expr
+	Consequent Expr
+	Alternate  Expr
+}
+
+func If(test, consequent, alternate Expr) *Conditional {
+	return &Conditional{Test: test, Consequent: consequent, Alternate: alternate}
+}
+
+func (c *Conditional) Expr() (string, error) {
+	test, err := render(c.Test)
+	if err != nil {
+		return "", err
+	}
+	consequent, err := render(c.Consequent)
+	if err != nil {
+		return "", err
+	}
+	alternate, err := render(c.Alternate)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("if %s then %s else %s", test, consequent, alternate), nil
+}
+
+// Property is one key of a record literal.
+type Property struct {
+	Key   string
+	Value Expr
+}
+
+func Prop(key string, value Expr) Property {
+	return Property{Key: key, Value: value}
+}
+
+// RecordExpr builds a record. With set, the record extends With, e.g.
+// {r with celsius: ...}, otherwise only Properties are present.
+type RecordExpr struct {
+	With       Expr
+	Properties []Property
+}
+
+func Record(props ...Property) *RecordExpr {
+	return &RecordExpr{Properties: props}
+}
+
+func With(base Expr, props ...Property) *RecordExpr {
+	return &RecordExpr{With: base, Properties: props}
+}
+
+func (r *RecordExpr) Expr() (string, error) {
+	props := make([]string, 0, len(r.Properties))
+	for _, p := range r.Properties {
+		key := p.Key
+		if err := validIdentifier(key); err != nil {
+			if key == "" {
+				return "", fmt.Errorf("empty record key")
+			}
+			key = lit.String(key)
+		}
+		v, err := render(p.Value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", p.Key, err)
+		}
+		props = append(props, fmt.Sprintf("%s: %s", key, v))
+	}
+	if r.With == nil {
+		return fmt.Sprintf("{%s}", strings.Join(props, ", ")), nil
+	}
+	if len(props) == 0 {
+		return "", fmt.Errorf("record with requires at least one property")
+	}
+	base, err := operand(r.With, 9)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("{%s with %s}", base, strings.Join(props, ", ")), nil
+}
+
+// Function is a Flux lambda. It is what every fn: argument expects.
+type Function struct {
+	Params []string
+	Body   Expr
+}
+
+// Fn builds the single record lambda (r) => body used by filter(), map(),
+// stateCount() and friends.
+func Fn(body Expr) *Function {
+	return &Function{Params: []string{"r"}, Body: body}
+}
+
+func Lambda(params []string, body Expr) *Function {
+	return &Function{Params: params, Body: body}
+}
+
+func (f *Function) Expr() (string, error) {
+	if len(f.Params) == 0 {
+		return "", fmt.Errorf("function requires at least one parameter")
+	}
+	for _, p := range f.Params {
+		if err := validIdentifier(p); err != nil {
+			return "", err
+		}
+	}
+	body, err := render(f.Body)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := f.Body.(*RecordExpr); ok {
+		// a bare brace after => would be parsed as a block
+		body = fmt.Sprintf("(%s)", body)
+	}
+	return fmt.Sprintf("(%s) => %s", strings.Join(f.Params, ", "), body), nil
+}
+
+func render(e Expr) (string, error) {
+	if e == nil {
+		return "", fmt.Errorf("missing expression")
+	}
+	return e.Expr()
+}
+
+// precedence follows the Flux operator precedence, higher binds tighter.
+func precedence(e Expr) int {
+	switch v := e.(type) {
+	case *Logical:
+		if len(v.Operands) == 1 {
+			return precedence(v.Operands[0])
+		}
+		if v.Op == OpAnd {
+			return 2
+		}
+		return 1
+	case *Binary:
+		switch v.Op {
+		case OpOr:
+			return 1
+		case OpAnd:
+			return 2
+		case OpAdd, OpSub:
+			return 5
+		case OpMul, OpDiv, OpMod:
+			return 6
+		case OpPow:
+			return 7
+		default:
+			return 4
+		}
+	case *Unary:
+		if v.Op == OpNegative {
+			return 8
+		}
+		return 3
+	case *Conditional, *Function:
+		return 0
+	default:
+		return 9
+	}
+}
+
+// Operand renders e as an operand of op, wrapping it in parentheses when
+// needed, for callers that splice expressions into their own Flux.
+func Operand(e Expr, op Operator) (string, error) {
+	min := precedence(&Binary{Op: op})
+	if op != OpAnd && op != OpOr {
+		min++
+	}
+	return operand(e, min)
+}
+
+// operand renders e and wraps it in parentheses when it binds looser than
+// min, so the composed tree never changes meaning once rendered.
+func operand(e Expr, min int) (string, error) {
+	s, err := render(e)
+	if err != nil {
+		return "", err
+	}
+	if precedence(e) < min {
+		return fmt.Sprintf("(%s)", s), nil
+	}
+	return s, nil
+}
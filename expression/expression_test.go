@@ -0,0 +1,84 @@
+package expression
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpr(t *testing.T) {
+	cases := []struct {
+		expr     Expr
+		expected string
+	}{
+		{
+			Fn(And(Eq(Col("_measurement"), String("cpu")), GT(Col("_value"), Float(80)))),
+			`(r) => r._measurement == "cpu" and r._value > 80.0`,
+		},
+		{
+			Fn(Not(Or(Match(Col("host"), Regex("gw-.*")), Exists(Col("site"))))),
+			`(r) => not (r.host =~ /gw-.*/ or exists r.site)`,
+		},
+		{
+			Fn(Div(Sub(Col("_value"), Float(32)), Float(1.8))),
+			`(r) => (r._value - 32.0) / 1.8`,
+		},
+		{
+			Fn(If(GTE(Call("math.abs", Named("x", Col("_value"))), Int(10)), String("high"), String("low"))),
+			`(r) => if math.abs(x: r._value) >= 10 then "high" else "low"`,
+		},
+		{
+			Lambda([]string{"l", "r"}, Eq(Column("l", "_time"), Column("r", "_time"))),
+			`(l, r) => l._time == r._time`,
+		},
+		{
+			Fn(With(Ident("r"), Prop("fahrenheit", Add(Mul(Col("_value"), Float(1.8)), Float(32))), Prop("unit-name", String("F")))),
+			`(r) => ({r with fahrenheit: r._value * 1.8 + 32.0, "unit-name": "F"})`,
+		},
+		{
+			Add(Pow(Pow(Col("_value"), Int(2)), Int(3)), Pow(Int(2), Pow(Int(3), Int(2)))),
+			`(r._value ^ 2) ^ 3 + 2 ^ 3 ^ 2`,
+		},
+		{
+			Eq(Col("_time"), Time(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))),
+			`r._time == 2024-01-02T03:04:05Z`,
+		},
+		{
+			Eq(Col("path"), String(`a"b\${c}`)),
+			`r.path == "a\"b\\\${c}"`,
+		},
+		{
+			Eq(Col("my-tag"), String("a")),
+			`r["my-tag"] == "a"`,
+		},
+		{
+			Match(Col("path"), Regex(`^/var/log\/x`)),
+			`r.path =~ /^\/var\/log\/x/`,
+		},
+	}
+	for _, c := range cases {
+		s, err := c.expr.Expr()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if s != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, s)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	cases := []Expr{
+		Fn(nil),
+		Column("1r", "_value"),
+		Duration("1 hour"),
+		Regex("("),
+		And(),
+		&Binary{Op: "=>", Left: Int(1), Right: Int(2)},
+	}
+	for _, c := range cases {
+		if s, err := c.Expr(); err == nil {
+			t.Errorf("expected error, got %s", s)
+		}
+	}
+}
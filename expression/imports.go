@@ -0,0 +1,132 @@
+package expression
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Importer is implemented by anything that needs Flux packages outside of
+// the universe in order to be evaluated.
+type Importer interface {
+	Imports() []string
+}
+
+// Packages maps the identifier a package is referenced by to its import
+// path, for the packages whose path is not the identifier itself.
+var Packages = map[string]string{
+	"aggregate":  "experimental/aggregate",
+	"geo":        "experimental/geo",
+	"monitor":    "influxdata/influxdb/monitor",
+	"sample":     "influxdata/influxdb/sample",
+	"schema":     "influxdata/influxdb/schema",
+	"secrets":    "influxdata/influxdb/secrets",
+	"tasks":      "influxdata/influxdb/tasks",
+	"v1":         "influxdata/influxdb/v1",
+	"quantile":   "experimental/quantile",
+	"table":      "experimental/table",
+	"usage":      "experimental/usage",
+	"prometheus": "experimental/prometheus",
+	"influxdb":   "influxdata/influxdb",
+}
+
+// PackagePath returns the import path of the package referenced by ident.
+func PackagePath(ident string) string {
+	if path, ok := Packages[ident]; ok {
+		return path
+	}
+	return ident
+}
+
+// Imports collects the packages required by e, deduplicated and sorted.
+func Imports(e Expr) []string {
+	seen := map[string]bool{}
+	collect(e, seen)
+	return Sorted(seen)
+}
+
+// Sorted returns the keys of a package set in import order.
+func Sorted(set map[string]bool) []string {
+	var imports []string
+	for i := range set {
+		if i != "" && i != "universe" {
+			imports = append(imports, i)
+		}
+	}
+	sort.Strings(imports)
+	return imports
+}
+
+func collect(e Expr, seen map[string]bool) {
+	if i, ok := e.(Importer); ok {
+		for _, p := range i.Imports() {
+			seen[p] = true
+		}
+	}
+	switch v := e.(type) {
+	case *CallExpr:
+		for _, a := range v.Args {
+			collect(a.Value, seen)
+		}
+	case *Binary:
+		collect(v.Left, seen)
+		collect(v.Right, seen)
+	case *Logical:
+		for _, o := range v.Operands {
+			collect(o, seen)
+		}
+	case *Unary:
+		collect(v.Operand, seen)
+	case *Conditional:
+		collect(v.Test, seen)
+		collect(v.Consequent, seen)
+		collect(v.Alternate, seen)
+	case *RecordExpr:
+		collect(v.With, seen)
+		for _, p := range v.Properties {
+			collect(p.Value, seen)
+		}
+	case *Function:
+		collect(v.Body, seen)
+	case *array:
+		for _, el := range v.elements {
+			collect(el, seen)
+		}
+	}
+}
+
+// Imports returns the package of a qualified call such as strings.title().
+func (c *CallExpr) Imports() []string {
+	if c.Package != "" {
+		return []string{c.Package}
+	}
+	if i := strings.LastIndex(c.Fn, "."); i > 0 {
+		return []string{PackagePath(c.Fn[:i])}
+	}
+	return nil
+}
+
+var (
+	qualifiedCall = regexp.MustCompile(`(^|[^.\w])([A-Za-z_]\w*)\.[A-Za-z_]\w*\s*\(`)
+	lambdaParams  = regexp.MustCompile(`\(([\w\s,]*)\)\s*=>`)
+	stringLiteral = regexp.MustCompile(`"(\\.|[^"\\])*"`)
+)
+
+// Imports guesses the packages of a raw expression from its qualified calls,
+// e.g. strings.hasPrefix(...). Lambda parameters and params are ignored.
+func (r Raw) Imports() []string {
+	src := stringLiteral.ReplaceAllString(string(r), `""`)
+	bound := map[string]bool{"params": true}
+	for _, m := range lambdaParams.FindAllStringSubmatch(src, -1) {
+		for _, p := range strings.Split(m[1], ",") {
+			bound[strings.TrimSpace(p)] = true
+		}
+	}
+	set := map[string]bool{}
+	for _, m := range qualifiedCall.FindAllStringSubmatch(src, -1) {
+		if !bound[m[2]] {
+			set[PackagePath(m[2])] = true
+		}
+	}
+	return Sorted(set)
+}
@@ -0,0 +1,197 @@
+package filter
+
+import "github.com/ThinkontrolSY/flux-builder/expression"
+
+// Builder builds FluxFilter trees fluently, e.g.
+//
+//	filter.Measurement("cpu").And(filter.Tag("host").Match(re)).Or(filter.Field("load")).Filter()
+//
+// Builders are not modified by And, Or and Not and can be reused.
+type Builder struct {
+	f *FluxFilter
+	// op is opAnd or opOr when f is a group made by the builder, which
+	// further calls of the same operator extend.
+	op int
+}
+
+// Filter returns the FluxFilter built.
+func (b *Builder) Filter() *FluxFilter {
+	return b.f
+}
+
+// And matches when b and all others match.
+func (b *Builder) And(others ...*Builder) *Builder {
+	return group(opAnd, b, others)
+}
+
+// Or matches when b or any of others matches. And binds tighter, so
+// a.And(b).Or(c) reads as (a and b) or c.
+func (b *Builder) Or(others ...*Builder) *Builder {
+	return group(opOr, b, others)
+}
+
+// Not matches when b does not.
+func (b *Builder) Not() *Builder {
+	return &Builder{f: &FluxFilter{Not: b.f}}
+}
+
+func group(op int, b *Builder, others []*Builder) *Builder {
+	var children []*FluxFilter
+	for _, c := range append([]*Builder{b}, others...) {
+		switch {
+		case c.op == op && op == opAnd:
+			children = append(children, c.f.And...)
+		case c.op == op:
+			children = append(children, c.f.Or...)
+		default:
+			children = append(children, c.f)
+		}
+	}
+	if op == opAnd {
+		return &Builder{f: &FluxFilter{And: children}, op: op}
+	}
+	return &Builder{f: &FluxFilter{Or: children}, op: op}
+}
+
+func wrap(f *FluxFilter) *Builder {
+	return &Builder{f: f}
+}
+
+// Measurement matches the records of the measurement name.
+func Measurement(name string) *Builder {
+	return Col("_measurement").Eq(name)
+}
+
+// Field matches the records of the field name.
+func Field(name string) *Builder {
+	return Col("_field").Eq(name)
+}
+
+// ColumnRef is a column to build predicates on, see Col.
+type ColumnRef struct {
+	name string
+}
+
+// Col references a column. _measurement and _field use the fields of
+// FluxFilter for them, other columns tag predicates or comparisons.
+func Col(name string) *ColumnRef {
+	return &ColumnRef{name: name}
+}
+
+// Tag references the tag key.
+func Tag(key string) *ColumnRef {
+	return Col(key)
+}
+
+// Value references the _value column.
+func Value() *ColumnRef {
+	return Col("_value")
+}
+
+// Eq matches the records where the column equals v.
+func (c *ColumnRef) Eq(v string) *Builder {
+	switch c.name {
+	case "_measurement":
+		return wrap(&FluxFilter{Measurement: &v})
+	case "_field":
+		return wrap(&FluxFilter{Field: &v})
+	case "_value":
+		return c.Compare(OpEq, StringValue(v))
+	}
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagEq, Value: v}))
+}
+
+// NEQ matches the records where the column is not v.
+func (c *ColumnRef) NEQ(v string) *Builder {
+	switch c.name {
+	case "_measurement":
+		return wrap(&FluxFilter{MeasurementNEQ: &v})
+	case "_field":
+		return wrap(&FluxFilter{FieldNEQ: &v})
+	case "_value":
+		return c.Compare(OpNEQ, StringValue(v))
+	}
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagNEQ, Value: v}))
+}
+
+// Match matches the records where the column matches re.
+func (c *ColumnRef) Match(re Regex) *Builder {
+	switch c.name {
+	case "_measurement":
+		return wrap(&FluxFilter{MeasurementMatch: &re})
+	case "_field":
+		return wrap(&FluxFilter{FieldMatch: &re})
+	case "_value":
+		return wrap(&FluxFilter{Expr: expression.Match(expression.Col(c.name), expression.Regex(re.Pattern()))})
+	}
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagMatch, Regex: re}))
+}
+
+// NMatch matches the records where the column does not match re.
+func (c *ColumnRef) NMatch(re Regex) *Builder {
+	switch c.name {
+	case "_measurement":
+		return wrap(&FluxFilter{MeasurementNMatch: &re})
+	case "_field":
+		return wrap(&FluxFilter{FieldNMatch: &re})
+	case "_value":
+		return wrap(&FluxFilter{Expr: expression.NMatch(expression.Col(c.name), expression.Regex(re.Pattern()))})
+	}
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagNMatch, Regex: re}))
+}
+
+// In matches the records where the column is one of values.
+func (c *ColumnRef) In(values ...string) *Builder {
+	switch c.name {
+	case "_measurement":
+		return wrap(&FluxFilter{MeasurementIn: values})
+	case "_field":
+		return wrap(&FluxFilter{FieldIn: values})
+	}
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagIn, Values: values}))
+}
+
+// NotIn matches the records where the column is none of values.
+func (c *ColumnRef) NotIn(values ...string) *Builder {
+	switch c.name {
+	case "_measurement":
+		return wrap(&FluxFilter{MeasurementNotIn: values})
+	case "_field":
+		return wrap(&FluxFilter{FieldNotIn: values})
+	}
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagNotIn, Values: values}))
+}
+
+// Exists matches the records where the column is set.
+func (c *ColumnRef) Exists() *Builder {
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagExists}))
+}
+
+// NotExists matches the records where the column is missing or null.
+func (c *ColumnRef) NotExists() *Builder {
+	return wrap(tagFilter(&TagPredicate{Key: c.name, Op: TagNotExists}))
+}
+
+// Compare compares the column with a typed operand, e.g.
+// Value().Compare(OpGT, FloatValue(10)).
+func (c *ColumnRef) Compare(op CompareOp, v Operand) *Builder {
+	return wrap(&FluxFilter{Compare: []*Comparison{{Column: c.comparisonColumn(), Op: op, Value: v}}})
+}
+
+func (c *ColumnRef) LT(v Operand) *Builder  { return c.Compare(OpLT, v) }
+func (c *ColumnRef) LTE(v Operand) *Builder { return c.Compare(OpLTE, v) }
+func (c *ColumnRef) GT(v Operand) *Builder  { return c.Compare(OpGT, v) }
+func (c *ColumnRef) GTE(v Operand) *Builder { return c.Compare(OpGTE, v) }
+
+// Between matches the records where the column is within from and to,
+// bounds included.
+func (c *ColumnRef) Between(from, to Operand) *Builder {
+	return wrap(&FluxFilter{Compare: []*Comparison{{Column: c.comparisonColumn(), Op: OpBetween, Value: from, To: &to}}})
+}
+
+func (c *ColumnRef) comparisonColumn() string {
+	if c.name == "_value" {
+		return ""
+	}
+	return c.name
+}
@@ -0,0 +1,39 @@
+package filter
+
+import "testing"
+
+func TestBuilder(t *testing.T) {
+	gw := MustRegex("gw-.*")
+	b := Measurement("measure-sensor").
+		And(Field("SoilTemperature").Or(Tag("host").Match(gw)), Tag("site").Exists().Not()).
+		And(Value().Between(FloatValue(0), FloatValue(40)))
+	parsed, err := Parse(`measurement = "measure-sensor" AND (field = "SoilTemperature" OR host =~ /gw-.*/) AND NOT exists site AND value BETWEEN 0.0 AND 40.0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := b.Filter().Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := parsed.Pipe(); s != expected {
+		t.Errorf("unexpected filter:\n%s\nexpected:\n%s", s, expected)
+	}
+
+	host := Tag("host")
+	or := host.Eq("a").Or(host.Eq("b")).Or(host.In("c", "d"))
+	if n := len(or.Filter().Or); n != 3 {
+		t.Errorf("expected a flat or group, got %d children", n)
+	}
+	if s, _ := host.Eq("a").Or(host.Eq("b")).Not().Filter().Pipe(); s != `|> filter(fn: (r) => not (r.host == "a" or r.host == "b"))` {
+		t.Errorf("unexpected negation: %s", s)
+	}
+	if s, _ := host.In("a", "b").Not().Filter().Pipe(); s != `|> filter(fn: (r) => not (r.host == "a" or r.host == "b"))` {
+		t.Errorf("unexpected negated set: %s", s)
+	}
+	if s, _ := host.Eq("a").Or(host.Eq("b")).And(Field("f")).Not().Filter().Pipe(); s != `|> filter(fn: (r) => not ((r.host == "a" or r.host == "b") and r._field == "f"))` {
+		t.Errorf("unexpected negated conjunction: %s", s)
+	}
+	if s, _ := Value().GT(IntValue(3)).And(Value().Match(MustRegex("^x"))).Filter().Pipe(); s != `|> filter(fn: (r) => r._value > 3 and r._value =~ /^x/)` {
+		t.Errorf("unexpected value predicates: %s", s)
+	}
+}
@@ -0,0 +1,284 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/literal"
+)
+
+type CompareOp string
+
+const (
+	OpEq  CompareOp = "=="
+	OpNEQ CompareOp = "!="
+	OpLT  CompareOp = "<"
+	OpLTE CompareOp = "<="
+	OpGT  CompareOp = ">"
+	OpGTE CompareOp = ">="
+	// OpBetween matches Value <= column <= To.
+	OpBetween CompareOp = "between"
+)
+
+type OperandType string
+
+const (
+	TypeInt    OperandType = "int"
+	TypeUInt   OperandType = "uint"
+	TypeFloat  OperandType = "float"
+	TypeBool   OperandType = "bool"
+	TypeString OperandType = "string"
+	TypeTime   OperandType = "time"
+)
+
+// Operand is a typed value of a Comparison. Value holds int64, uint64,
+// float64, bool, string or time.Time; other Go numbers, json.Number and
+// RFC3339 strings for times are converted when the operand is rendered.
+type Operand struct {
+	Type  OperandType `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func IntValue(v int64) Operand      { return Operand{Type: TypeInt, Value: v} }
+func UIntValue(v uint64) Operand    { return Operand{Type: TypeUInt, Value: v} }
+func FloatValue(v float64) Operand  { return Operand{Type: TypeFloat, Value: v} }
+func BoolValue(v bool) Operand      { return Operand{Type: TypeBool, Value: v} }
+func StringValue(v string) Operand  { return Operand{Type: TypeString, Value: v} }
+func TimeValue(v time.Time) Operand { return Operand{Type: TypeTime, Value: v} }
+
+// UnmarshalJSON reads the value as the declared type, so that numbers keep
+// their Flux type and times are parsed.
+func (o *Operand) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Type  OperandType `json:"type"`
+		Value interface{} `json:"value"`
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&raw); err != nil {
+		return err
+	}
+	v, err := Operand{Type: raw.Type, Value: raw.Value}.normalize()
+	if err != nil {
+		return err
+	}
+	*o = Operand{Type: raw.Type, Value: v}
+	return nil
+}
+
+// normalize converts the value into the Go type of the operand type.
+func (o Operand) normalize() (interface{}, error) {
+	switch o.Type {
+	case TypeInt:
+		switch v := o.Value.(type) {
+		case int:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+				return int64(v), nil
+			}
+		case json.Number:
+			if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+	case TypeUInt:
+		switch v := o.Value.(type) {
+		case uint:
+			return uint64(v), nil
+		case uint32:
+			return uint64(v), nil
+		case uint64:
+			return v, nil
+		case int:
+			if v >= 0 {
+				return uint64(v), nil
+			}
+		case int64:
+			if v >= 0 {
+				return uint64(v), nil
+			}
+		case float64:
+			if v >= 0 && v == math.Trunc(v) && v < 1<<64 {
+				return uint64(v), nil
+			}
+		case json.Number:
+			if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+				return u, nil
+			}
+		}
+	case TypeFloat:
+		switch v := o.Value.(type) {
+		case float32:
+			return float64(v), nil
+		case float64:
+			if !math.IsInf(v, 0) && !math.IsNaN(v) {
+				return v, nil
+			}
+		case int:
+			return float64(v), nil
+		case int64:
+			return float64(v), nil
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				return f, nil
+			}
+		}
+	case TypeBool:
+		if v, ok := o.Value.(bool); ok {
+			return v, nil
+		}
+	case TypeString:
+		if v, ok := o.Value.(string); ok {
+			return v, nil
+		}
+	case TypeTime:
+		switch v := o.Value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid operand type: %q", o.Type)
+	}
+	return nil, fmt.Errorf("invalid %s operand: %v", o.Type, o.Value)
+}
+
+// flux renders the operand as a Flux literal. Strings and times are bound
+// through b.
+func (o Operand) flux(b *literal.Binder) (string, error) {
+	v, err := o.normalize()
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return b.String(v), nil
+	case time.Time:
+		return b.Time(v), nil
+	}
+	return literal.Value(v)
+}
+
+// Comparison compares a column with a typed operand, e.g. r._value > 10.5.
+type Comparison struct {
+	// Column defaults to _value.
+	Column string    `json:"column,omitempty"`
+	Op     CompareOp `json:"op"`
+	Value  Operand   `json:"value"`
+	// To is the upper bound of OpBetween.
+	To *Operand `json:"to,omitempty"`
+}
+
+func (c *Comparison) column() string {
+	if c.Column == "" {
+		return "_value"
+	}
+	return c.Column
+}
+
+// Validate checks the operator against the type of the operands.
+func (c *Comparison) Validate() error {
+	if _, err := c.Value.normalize(); err != nil {
+		return err
+	}
+	switch c.Op {
+	case OpEq, OpNEQ:
+	case OpLT, OpLTE, OpGT, OpGTE, OpBetween:
+		if c.Value.Type == TypeBool {
+			return fmt.Errorf("operator %s is not supported on bool operands", c.Op)
+		}
+	default:
+		return fmt.Errorf("invalid comparison operator: %q", c.Op)
+	}
+	if c.Op != OpBetween {
+		if c.To != nil {
+			return fmt.Errorf("to is only supported by between")
+		}
+		return nil
+	}
+	if c.To == nil {
+		return fmt.Errorf("between requires to")
+	}
+	if c.To.Type != c.Value.Type {
+		return fmt.Errorf("between bounds must have the same type, got %s and %s", c.Value.Type, c.To.Type)
+	}
+	_, err := c.To.normalize()
+	return err
+}
+
+func (c *Comparison) flux(b *literal.Binder) (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	ref := literal.Member("r", c.column())
+	v, err := c.Value.flux(b)
+	if err != nil {
+		return "", err
+	}
+	if c.Op != OpBetween {
+		return fmt.Sprintf("%s %s %s", ref, c.Op, v), nil
+	}
+	to, err := c.To.flux(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s >= %s and %s <= %s", ref, v, ref, to), nil
+}
+
+// negate returns the comparisons of which one matches when c does not.
+func (c *Comparison) negate() []*Comparison {
+	inverse := map[CompareOp]CompareOp{OpEq: OpNEQ, OpNEQ: OpEq, OpLT: OpGTE, OpGTE: OpLT, OpLTE: OpGT, OpGT: OpLTE}
+	if op, ok := inverse[c.Op]; ok {
+		return []*Comparison{{Column: c.Column, Op: op, Value: c.Value}}
+	}
+	if c.Op == OpBetween && c.To != nil {
+		return []*Comparison{{Column: c.Column, Op: OpLT, Value: c.Value}, {Column: c.Column, Op: OpGT, Value: *c.To}}
+	}
+	return nil
+}
+
+// legacyValue reads the former Value field, an operator followed by a Flux
+// literal such as "> 10.5", into a comparison on _value.
+func legacyValue(s string) (*Comparison, error) {
+	s = strings.TrimSpace(s)
+	var op CompareOp
+	for _, o := range []CompareOp{OpEq, OpNEQ, OpLTE, OpGTE, OpLT, OpGT} {
+		if strings.HasPrefix(s, string(o)) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("invalid value predicate: %q", s)
+	}
+	v := strings.TrimSpace(strings.TrimPrefix(s, string(op)))
+	var operand Operand
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		operand = IntValue(i)
+	} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+		operand = FloatValue(f)
+	} else if b, err := strconv.ParseBool(v); err == nil && (v == "true" || v == "false") {
+		operand = BoolValue(b)
+	} else if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		operand = TimeValue(t)
+	} else if str, err := strconv.Unquote(v); err == nil && strings.HasPrefix(v, `"`) {
+		operand = StringValue(str)
+	} else {
+		return nil, fmt.Errorf("invalid value predicate: %q", s)
+	}
+	c := &Comparison{Op: op, Value: operand}
+	return c, c.Validate()
+}
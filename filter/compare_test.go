@@ -0,0 +1,63 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestComparison(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := &FluxFilter{Compare: []*Comparison{
+		{Op: OpGT, Value: FloatValue(20)},
+		{Column: "count", Op: OpBetween, Value: IntValue(1), To: &Operand{Type: TypeInt, Value: 10}},
+		{Column: "total", Op: OpLTE, Value: UIntValue(5)},
+		{Column: "ok", Op: OpEq, Value: BoolValue(true)},
+		{Column: "level", Op: OpNEQ, Value: StringValue("debug")},
+		{Column: "seen", Op: OpLT, Value: TimeValue(at)},
+	}}
+	s, err := f.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `|> filter(fn: (r) => r._value > 20.0 and r.count >= 1 and r.count <= 10 and r.total <= uint(v: 5) and r.ok == true and r.level != "debug" and r.seen < 2024-01-01T00:00:00Z)`
+	if s != expected {
+		t.Errorf("unexpected filter:\n%s", s)
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back FluxFilter
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := back.Pipe(); s != expected {
+		t.Errorf("unexpected filter after a JSON round trip:\n%s", s)
+	}
+
+	var legacy FluxFilter
+	if err := json.Unmarshal([]byte(`{"value": ">= 10.5"}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if c := legacy.Compare[0]; c.Op != OpGTE || c.Value.Type != TypeFloat || c.Value.Value != 10.5 {
+		t.Errorf("unexpected legacy comparison: %+v", c)
+	}
+
+	for _, c := range []*Comparison{
+		{Op: OpLT, Value: BoolValue(true)},
+		{Op: OpEq, Value: Operand{Type: TypeInt, Value: "1"}},
+		{Op: OpBetween, Value: IntValue(1), To: &Operand{Type: TypeFloat, Value: 2.0}},
+		{Op: "~", Value: IntValue(1)},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+
+	not := Optimize(&FluxFilter{Not: &FluxFilter{Compare: f.Compare[1:2]}})
+	if s, _ := not.Pipe(); s != `|> filter(fn: (r) => (r.count < 1 or r.count > 10))` {
+		t.Errorf("unexpected negation: %s", s)
+	}
+}
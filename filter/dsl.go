@@ -0,0 +1,700 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file holds a small filter language for search boxes, e.g.
+//
+//	measurement = "cpu" AND (field = "usage" OR host =~ /gw-.*/) AND NOT exists site
+//
+// Predicates compare a column with a value:
+//
+//   - =, ==, !=, <, <=, > and >= with a string, number, true, false or an
+//     RFC3339 time
+//   - =~ and !~ with a regex literal such as /gw-.*/, or /gw-.*/i to ignore
+//     the case
+//   - IN ("a", "b") and NOT IN ("a", "b") with strings
+//   - BETWEEN 1 AND 5, bounds included
+//   - exists host
+//
+// measurement, field and value stand for the columns _measurement, _field
+// and _value, other names are tags or columns. A name that is not an
+// identifier or a keyword is written as a string, e.g. "my-tag" = "a".
+// NOT binds tighter than AND, which binds tighter than OR. Keywords are case
+// insensitive.
+
+type dslKind int
+
+const (
+	dslEOF dslKind = iota
+	dslIdent
+	dslString
+	dslRegex
+	dslNumber
+	dslPunct
+)
+
+type dslToken struct {
+	kind dslKind
+	text string
+	// value is the decoded content of strings and regexes
+	value string
+	pos   int
+}
+
+var (
+	dslIdentToken  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+	dslNumberToken = regexp.MustCompile(`^-?[0-9][0-9A-Za-z.:+-]*`)
+)
+
+var dslPuncts = []string{"==", "!=", "<=", ">=", "=~", "!~", "(", ")", ",", "=", "<", ">"}
+
+var dslKeywords = map[string]bool{
+	"and": true, "or": true, "not": true, "exists": true, "in": true, "between": true, "true": true, "false": true,
+}
+
+// dslColumns maps the short names of the reserved columns.
+var dslColumns = map[string]string{
+	"measurement": "_measurement", "_measurement": "_measurement",
+	"field": "_field", "_field": "_field",
+	"value": "_value", "_value": "_value",
+}
+
+func lexDSL(src string) ([]dslToken, error) {
+	var tokens []dslToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"':
+			j := i + 1
+			for ; j < len(src) && src[j] != '"'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, dslError(src, i, "unterminated string")
+			}
+			value, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, dslError(src, i, "invalid string")
+			}
+			tokens = append(tokens, dslToken{kind: dslString, text: src[i : j+1], value: value, pos: i})
+			i = j + 1
+			continue
+		case c == '/':
+			j := i + 1
+			for ; j < len(src) && src[j] != '/'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, dslError(src, i, "unterminated regex")
+			}
+			re := Regex(src[i+1 : j])
+			// a trailing i matches case-insensitively, as in /gw-.*/i
+			if j+1 < len(src) && src[j+1] == 'i' && (j+2 == len(src) || !isIdentByte(src[j+2])) {
+				re = re.IgnoreCase()
+				j++
+			}
+			if err := re.Validate(); err != nil {
+				return nil, dslError(src, i, err.Error())
+			}
+			tokens = append(tokens, dslToken{kind: dslRegex, text: src[i : j+1], value: string(re), pos: i})
+			i = j + 1
+			continue
+		}
+		if s := dslNumberToken.FindString(src[i:]); s != "" {
+			tokens = append(tokens, dslToken{kind: dslNumber, text: s, pos: i})
+			i += len(s)
+			continue
+		}
+		if s := dslIdentToken.FindString(src[i:]); s != "" {
+			tokens = append(tokens, dslToken{kind: dslIdent, text: s, pos: i})
+			i += len(s)
+			continue
+		}
+		matched := false
+		for _, p := range dslPuncts {
+			if strings.HasPrefix(src[i:], p) {
+				tokens = append(tokens, dslToken{kind: dslPunct, text: p, pos: i})
+				i += len(p)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, dslError(src, i, fmt.Sprintf("unexpected character %q", c))
+		}
+	}
+	return append(tokens, dslToken{kind: dslEOF, pos: len(src)}), nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func dslError(src string, pos int, msg string) error {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	line := strings.Count(src[:pos], "\n") + 1
+	col := pos - strings.LastIndex(src[:pos], "\n")
+	return fmt.Errorf("%d:%d: %s", line, col, msg)
+}
+
+type dslParser struct {
+	src    string
+	tokens []dslToken
+	pos    int
+}
+
+// Parse reads a filter written in the filter language into a FluxFilter
+// tree.
+func Parse(src string) (*FluxFilter, error) {
+	tokens, err := lexDSL(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &dslParser{src: src, tokens: tokens}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != dslEOF {
+		return nil, p.errorf(t, "unexpected %s", t.text)
+	}
+	return f, nil
+}
+
+func (p *dslParser) peek() dslToken {
+	return p.tokens[p.pos]
+}
+
+func (p *dslParser) next() dslToken {
+	t := p.tokens[p.pos]
+	if t.kind != dslEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the keyword kw.
+func (p *dslParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == dslIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *dslParser) punct(s string) bool {
+	if t := p.peek(); t.kind == dslPunct && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *dslParser) errorf(t dslToken, format string, args ...interface{}) error {
+	if t.kind == dslEOF {
+		return dslError(p.src, t.pos, "unexpected end of filter")
+	}
+	return dslError(p.src, t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *dslParser) or() (*FluxFilter, error) {
+	var terms []*FluxFilter
+	for {
+		f, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, f)
+		if !p.keyword("or") {
+			break
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return &FluxFilter{Or: terms}, nil
+}
+
+func (p *dslParser) and() (*FluxFilter, error) {
+	var terms []*FluxFilter
+	for {
+		f, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, f)
+		if !p.keyword("and") {
+			break
+		}
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return &FluxFilter{And: terms}, nil
+}
+
+func (p *dslParser) not() (*FluxFilter, error) {
+	if p.keyword("not") {
+		f, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &FluxFilter{Not: f}, nil
+	}
+	if p.punct("(") {
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != dslPunct || t.text != ")" {
+			return nil, p.errorf(t, "expected ) but got %s", t.text)
+		}
+		return f, nil
+	}
+	if p.keyword("exists") {
+		col, err := p.column()
+		if err != nil {
+			return nil, err
+		}
+		return tagFilter(&TagPredicate{Key: col, Op: TagExists}), nil
+	}
+	return p.predicate()
+}
+
+// column reads a column name, resolving the short names of the reserved
+// columns.
+func (p *dslParser) column() (string, error) {
+	t := p.next()
+	switch {
+	case t.kind == dslString && t.value != "":
+		return t.value, nil
+	case t.kind == dslIdent && !dslKeywords[strings.ToLower(t.text)]:
+		if col, ok := dslColumns[t.text]; ok {
+			return col, nil
+		}
+		return t.text, nil
+	}
+	return "", p.errorf(t, "expected a column but got %s", t.text)
+}
+
+func (p *dslParser) predicate() (*FluxFilter, error) {
+	at := p.peek()
+	col, err := p.column()
+	if err != nil {
+		return nil, err
+	}
+	if p.keyword("not") {
+		if !p.keyword("in") {
+			t := p.peek()
+			return nil, p.errorf(t, "expected IN but got %s", t.text)
+		}
+		return p.set(at, col, false)
+	}
+	if p.keyword("in") {
+		return p.set(at, col, true)
+	}
+	if p.keyword("between") {
+		from, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if !p.keyword("and") {
+			t := p.peek()
+			return nil, p.errorf(t, "expected AND but got %s", t.text)
+		}
+		to, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return p.compare(at, col, OpBetween, from, &to)
+	}
+
+	t := p.next()
+	if t.kind != dslPunct || t.text == "(" || t.text == ")" || t.text == "," {
+		return nil, p.errorf(t, "expected an operator but got %s", t.text)
+	}
+	op := t.text
+	if op == "=" {
+		op = "=="
+	}
+	if op == "=~" || op == "!~" {
+		v := p.next()
+		if v.kind != dslRegex {
+			return nil, p.errorf(v, "expected a regex but got %s", v.text)
+		}
+		return match(p.src, at, col, op == "=~", Regex(v.value))
+	}
+	v, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := v.Value.(string); ok && col != "_value" && (op == "==" || op == "!=") {
+		return equal(col, op == "==", s), nil
+	}
+	return p.compare(at, col, CompareOp(op), v, nil)
+}
+
+func (p *dslParser) operand() (Operand, error) {
+	t := p.next()
+	switch t.kind {
+	case dslString:
+		return StringValue(t.value), nil
+	case dslIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return BoolValue(true), nil
+		case "false":
+			return BoolValue(false), nil
+		}
+	case dslNumber:
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return IntValue(i), nil
+		}
+		if f, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return FloatValue(f), nil
+		}
+		if v, err := time.Parse(time.RFC3339Nano, t.text); err == nil {
+			return TimeValue(v), nil
+		}
+		return Operand{}, p.errorf(t, "invalid number or time %s", t.text)
+	}
+	return Operand{}, p.errorf(t, "expected a value but got %s", t.text)
+}
+
+func (p *dslParser) compare(at dslToken, col string, op CompareOp, v Operand, to *Operand) (*FluxFilter, error) {
+	if col == "_value" {
+		col = ""
+	}
+	if to != nil {
+		// BETWEEN 0 AND 1.5 reads as a float range
+		if v.Type == TypeInt && to.Type == TypeFloat {
+			v = FloatValue(float64(v.Value.(int64)))
+		}
+		if v.Type == TypeFloat && to.Type == TypeInt {
+			to = &Operand{Type: TypeFloat, Value: float64(to.Value.(int64))}
+		}
+	}
+	c := &Comparison{Column: col, Op: op, Value: v, To: to}
+	if err := c.Validate(); err != nil {
+		return nil, dslError(p.src, at.pos, err.Error())
+	}
+	return &FluxFilter{Compare: []*Comparison{c}}, nil
+}
+
+func (p *dslParser) set(at dslToken, col string, in bool) (*FluxFilter, error) {
+	if t := p.next(); t.kind != dslPunct || t.text != "(" {
+		return nil, p.errorf(t, "expected ( but got %s", t.text)
+	}
+	values := []string{}
+	for {
+		t := p.next()
+		if t.kind != dslString {
+			return nil, p.errorf(t, "expected a string but got %s", t.text)
+		}
+		values = append(values, t.value)
+		if p.punct(")") {
+			break
+		}
+		if t := p.next(); t.kind != dslPunct || t.text != "," {
+			return nil, p.errorf(t, "expected , or ) but got %s", t.text)
+		}
+	}
+	switch col {
+	case "_value":
+		return nil, dslError(p.src, at.pos, "IN is not supported on value")
+	case "_measurement":
+		if in {
+			return &FluxFilter{MeasurementIn: values}, nil
+		}
+		return &FluxFilter{MeasurementNotIn: values}, nil
+	case "_field":
+		if in {
+			return &FluxFilter{FieldIn: values}, nil
+		}
+		return &FluxFilter{FieldNotIn: values}, nil
+	}
+	if in {
+		return tagFilter(&TagPredicate{Key: col, Op: TagIn, Values: values}), nil
+	}
+	return tagFilter(&TagPredicate{Key: col, Op: TagNotIn, Values: values}), nil
+}
+
+func equal(col string, eq bool, v string) *FluxFilter {
+	switch {
+	case col == "_measurement" && eq:
+		return &FluxFilter{Measurement: &v}
+	case col == "_measurement":
+		return &FluxFilter{MeasurementNEQ: &v}
+	case col == "_field" && eq:
+		return &FluxFilter{Field: &v}
+	case col == "_field":
+		return &FluxFilter{FieldNEQ: &v}
+	case eq:
+		return tagFilter(&TagPredicate{Key: col, Op: TagEq, Value: v})
+	}
+	return tagFilter(&TagPredicate{Key: col, Op: TagNEQ, Value: v})
+}
+
+func match(src string, at dslToken, col string, eq bool, re Regex) (*FluxFilter, error) {
+	switch {
+	case col == "_value":
+		return nil, dslError(src, at.pos, "regex is not supported on value")
+	case col == "_measurement" && eq:
+		return &FluxFilter{MeasurementMatch: &re}, nil
+	case col == "_measurement":
+		return &FluxFilter{MeasurementNMatch: &re}, nil
+	case col == "_field" && eq:
+		return &FluxFilter{FieldMatch: &re}, nil
+	case col == "_field":
+		return &FluxFilter{FieldNMatch: &re}, nil
+	case eq:
+		return tagFilter(&TagPredicate{Key: col, Op: TagMatch, Regex: re}), nil
+	}
+	return tagFilter(&TagPredicate{Key: col, Op: TagNMatch, Regex: re}), nil
+}
+
+func tagFilter(t *TagPredicate) *FluxFilter {
+	return &FluxFilter{Tags: []*TagPredicate{t}}
+}
+
+// precedence of the rendered filter language, to place parentheses
+const (
+	precOr = iota
+	precAnd
+	precAtom
+)
+
+// Format renders f in the filter language. Expr predicates have no form in
+// the language and return an error.
+func Format(f *FluxFilter) (string, error) {
+	s, _, err := f.dsl()
+	return s, err
+}
+
+func (f *FluxFilter) dsl() (string, int, error) {
+	var parts []string
+	var precs []int
+	add := func(s string, prec int) {
+		parts = append(parts, s)
+		precs = append(precs, prec)
+	}
+
+	if f.Not != nil {
+		s, prec, err := f.Not.dsl()
+		if err != nil {
+			return "", 0, err
+		}
+		if prec < precAtom {
+			s = "(" + s + ")"
+		}
+		add("NOT "+s, precAtom)
+	}
+
+	if len(f.Or) == 1 {
+		// a single child is not an or, it keeps its own precedence
+		s, prec, err := f.Or[0].dsl()
+		if err != nil {
+			return "", 0, err
+		}
+		add(s, prec)
+	} else if len(f.Or) > 0 {
+		or := make([]string, 0, len(f.Or))
+		for _, c := range f.Or {
+			s, prec, err := c.dsl()
+			if err != nil {
+				return "", 0, err
+			}
+			if prec == precAnd {
+				// and binds tighter, the parentheses are for the reader
+				s = "(" + s + ")"
+			}
+			or = append(or, s)
+		}
+		add(strings.Join(or, " OR "), precOr)
+	}
+
+	for _, c := range f.And {
+		s, prec, err := c.dsl()
+		if err != nil {
+			return "", 0, err
+		}
+		add(s, prec)
+	}
+
+	for _, a := range atoms(f) {
+		s, err := a.dslAtom()
+		if err != nil {
+			return "", 0, err
+		}
+		add(s, precAtom)
+	}
+
+	switch len(parts) {
+	case 0:
+		return "", 0, fmt.Errorf("empty predicate FluxFilter")
+	case 1:
+		return parts[0], precs[0], nil
+	}
+	for i, prec := range precs {
+		if prec == precOr {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, " AND "), precAnd, nil
+}
+
+// dslAtom renders a single predicate, see atoms.
+func (f *FluxFilter) dslAtom() (string, error) {
+	switch {
+	case f.Measurement != nil:
+		return "measurement = " + strconv.Quote(*f.Measurement), nil
+	case f.MeasurementNEQ != nil:
+		return "measurement != " + strconv.Quote(*f.MeasurementNEQ), nil
+	case f.MeasurementMatch != nil:
+		return dslMatch("measurement", "=~", *f.MeasurementMatch)
+	case f.MeasurementNMatch != nil:
+		return dslMatch("measurement", "!~", *f.MeasurementNMatch)
+	case f.MeasurementIn != nil:
+		return dslSet("measurement", "IN", f.MeasurementIn)
+	case f.MeasurementNotIn != nil:
+		return dslSet("measurement", "NOT IN", f.MeasurementNotIn)
+	case f.Field != nil:
+		return "field = " + strconv.Quote(*f.Field), nil
+	case f.FieldNEQ != nil:
+		return "field != " + strconv.Quote(*f.FieldNEQ), nil
+	case f.FieldMatch != nil:
+		return dslMatch("field", "=~", *f.FieldMatch)
+	case f.FieldNMatch != nil:
+		return dslMatch("field", "!~", *f.FieldNMatch)
+	case f.FieldIn != nil:
+		return dslSet("field", "IN", f.FieldIn)
+	case f.FieldNotIn != nil:
+		return dslSet("field", "NOT IN", f.FieldNotIn)
+	case len(f.Tags) == 1:
+		return f.Tags[0].dsl()
+	case len(f.Compare) == 1:
+		return f.Compare[0].dsl()
+	}
+	return "", fmt.Errorf("expr predicates have no form in the filter language")
+}
+
+// dslColumn renders a column name, quoted when it would not read back as
+// the same column.
+func dslColumn(col string) string {
+	switch col {
+	case "_measurement":
+		return "measurement"
+	case "_field":
+		return "field"
+	case "_value":
+		return "value"
+	}
+	if _, reserved := dslColumns[col]; reserved || dslKeywords[strings.ToLower(col)] || dslIdentToken.FindString(col) != col {
+		return strconv.Quote(col)
+	}
+	return col
+}
+
+func dslMatch(col, op string, re Regex) (string, error) {
+	s, err := re.Flux()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s %s", col, op, s), nil
+}
+
+func dslSet(col, op string, values []string) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("empty set for %s", col)
+	}
+	quoted := make([]string, 0, len(values))
+	for _, v := range values {
+		quoted = append(quoted, strconv.Quote(v))
+	}
+	return fmt.Sprintf("%s %s (%s)", col, op, strings.Join(quoted, ", ")), nil
+}
+
+func (t *TagPredicate) dsl() (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+	tag := dslColumn(t.Key)
+	switch t.Op {
+	case TagEq:
+		return tag + " = " + strconv.Quote(t.Value), nil
+	case TagNEQ:
+		return tag + " != " + strconv.Quote(t.Value), nil
+	case TagMatch, TagNMatch:
+		return dslMatch(tag, string(t.Op), t.Regex)
+	case TagIn:
+		return dslSet(tag, "IN", t.Values)
+	case TagNotIn:
+		return dslSet(tag, "NOT IN", t.Values)
+	case TagExists:
+		return "exists " + tag, nil
+	}
+	return "NOT exists " + tag, nil
+}
+
+func (c *Comparison) dsl() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+	col := dslColumn(c.column())
+	v, err := c.Value.dsl()
+	if err != nil {
+		return "", err
+	}
+	if c.Op != OpBetween {
+		op := string(c.Op)
+		if c.Op == OpEq {
+			op = "="
+		}
+		return fmt.Sprintf("%s %s %s", col, op, v), nil
+	}
+	to, err := c.To.dsl()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s BETWEEN %s AND %s", col, v, to), nil
+}
+
+// dsl renders the operand. Unsigned integers read back as int.
+func (o Operand) dsl() (string, error) {
+	v, err := o.normalize()
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		s := strconv.FormatFloat(v, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+	return strconv.Quote(v.(string)), nil
+}
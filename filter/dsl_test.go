@@ -0,0 +1,81 @@
+package filter
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	f, err := Parse(`measurement = "measure-sensor" AND (field = "SoilTemperature" OR host =~ /gw-.*/) and not exists site`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `|> filter(fn: (r) => r._measurement == "measure-sensor" and (r._field == "SoilTemperature" or r.host =~ /gw-.*/) and not (exists r.site))`
+	if s != expected {
+		t.Errorf("unexpected filter:\n%s", s)
+	}
+	dsl, err := Format(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsl != `measurement = "measure-sensor" AND (field = "SoilTemperature" OR host =~ /gw-.*/) AND NOT exists site` {
+		t.Errorf("unexpected dsl: %s", dsl)
+	}
+
+	for _, c := range []struct{ src, dsl string }{
+		{`value > 10 OR value BETWEEN -1.5 AND 2 AND "my-tag" != "a\"b"`, `value > 10 OR (value BETWEEN -1.5 AND 2.0 AND "my-tag" != "a\"b")`},
+		{`NOT (field IN ("a", "b") OR "value" NOT IN ("c")) AND _time < 2024-01-01T00:00:00Z`, `NOT (field IN ("a", "b") OR "value" NOT IN ("c")) AND _time < 2024-01-01T00:00:00Z`},
+		{`path =~ /a\/b/ AND ok == true`, `path =~ /a\/b/ AND ok = true`},
+	} {
+		f, err := Parse(c.src)
+		if err != nil {
+			t.Errorf("%s: %s", c.src, err)
+			continue
+		}
+		if dsl, err := Format(f); err != nil || dsl != c.dsl {
+			t.Errorf("%s: unexpected dsl %s (%v)", c.src, dsl, err)
+		}
+	}
+
+	for _, src := range []string{
+		`host = `,
+		`host = gw1`,
+		`(host = "a"`,
+		`host =~ /(/`,
+		`value =~ /a/`,
+		`and = "a"`,
+		`ok < true`,
+		`host = "a" "b"`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+}
+
+func TestFormat_RoundTrip(t *testing.T) {
+	a, b, c, m := "a", "b", "c", "m"
+	host := func(v string) *FluxFilter { return tagFilter(&TagPredicate{Key: "host", Op: TagEq, Value: v}) }
+	for _, f := range []*FluxFilter{
+		{Or: []*FluxFilter{{Or: []*FluxFilter{{Measurement: &a}, {Measurement: &b}}}}, Field: &m},
+		{Not: &FluxFilter{Or: []*FluxFilter{{Or: []*FluxFilter{host(a), host(b)}}}}, Field: &m},
+		{Not: &FluxFilter{And: []*FluxFilter{{Or: []*FluxFilter{host(a), {Not: host(b)}}}, {Field: &m}}}},
+		{Or: []*FluxFilter{{And: []*FluxFilter{{Not: &FluxFilter{Or: []*FluxFilter{host(a), host(b)}}}, host(c)}}, {Field: &m}}},
+		{And: []*FluxFilter{{Or: []*FluxFilter{{Not: &FluxFilter{Or: []*FluxFilter{host(a)}}}}}}, Or: []*FluxFilter{host(b), host(c)}},
+	} {
+		dsl, err := Format(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := Parse(dsl)
+		if err != nil {
+			t.Errorf("%s: %s", dsl, err)
+			continue
+		}
+		expected, _ := Optimize(f).Pipe()
+		if s, _ := Optimize(parsed).Pipe(); s != expected {
+			t.Errorf("%s reads back as %s, expected %s", dsl, s, expected)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package filter
+
+import (
+	"fmt"
+	"time"
+
+	iq "github.com/influxdata/influxdb-client-go/v2/api/query"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// truth is the result of a predicate in the three-valued logic of Flux: a
+// comparison with a missing column is null, not false, so that
+// r.host != "a" drops the records without a host as the query does.
+type truth int
+
+const (
+	isFalse truth = iota
+	isNull
+	isTrue
+)
+
+func (t truth) and(u truth) truth {
+	if t < u {
+		return t
+	}
+	return u
+}
+
+func (t truth) or(u truth) truth {
+	if t > u {
+		return t
+	}
+	return u
+}
+
+func (t truth) not() truth {
+	return isTrue - t
+}
+
+func truthOf(b bool) truth {
+	if b {
+		return isTrue
+	}
+	return isFalse
+}
+
+type evalFunc func(values map[string]interface{}) (truth, error)
+
+// Predicate is a filter compiled for evaluation in Go, e.g. to post-filter
+// cached results or points of the write path. Records match like rows of
+// the generated Flux: a predicate on a missing or null column is null and
+// drops the record, except for exists.
+type Predicate struct {
+	eval evalFunc
+}
+
+// Compile checks the filters and compiles them into a Predicate that
+// matches when all of them do. Filters with Expr predicates cannot be
+// compiled.
+func Compile(filters ...*FluxFilter) (*Predicate, error) {
+	var evals []evalFunc
+	for _, f := range filters {
+		if f == nil {
+			continue
+		}
+		e, err := f.compile()
+		if err != nil {
+			return nil, err
+		}
+		evals = append(evals, e)
+	}
+	return &Predicate{eval: allOf(evals)}, nil
+}
+
+// Match reports whether a record given as its column values matches.
+// Values of a column and of the operand it is compared with must have
+// compatible types, otherwise an error is returned as Flux would.
+func (p *Predicate) Match(values map[string]interface{}) (bool, error) {
+	t, err := p.eval(values)
+	return t == isTrue, err
+}
+
+// MatchRecord reports whether a record read by the client matches.
+func (p *Predicate) MatchRecord(r *iq.FluxRecord) (bool, error) {
+	return p.Match(r.Values())
+}
+
+// Match compiles f and matches a single record, see Predicate.
+func (f *FluxFilter) Match(values map[string]interface{}) (bool, error) {
+	p, err := Compile(f)
+	if err != nil {
+		return false, err
+	}
+	return p.Match(values)
+}
+
+// PointRecords returns the records a point is stored as, one per field,
+// with the columns _measurement, _field, _value, _time and the tags.
+func PointRecords(p *write.Point) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0, len(p.FieldList()))
+	for _, field := range p.FieldList() {
+		r := map[string]interface{}{
+			"_measurement": p.Name(),
+			"_field":       field.Key,
+			"_value":       field.Value,
+		}
+		if !p.Time().IsZero() {
+			r["_time"] = p.Time()
+		}
+		for _, tag := range p.TagList() {
+			r[tag.Key] = tag.Value
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func allOf(evals []evalFunc) evalFunc {
+	return func(values map[string]interface{}) (truth, error) {
+		result := isTrue
+		for _, e := range evals {
+			t, err := e(values)
+			if err != nil {
+				return isFalse, err
+			}
+			if result = result.and(t); result == isFalse {
+				break
+			}
+		}
+		return result, nil
+	}
+}
+
+func (f *FluxFilter) compile() (evalFunc, error) {
+	var evals []evalFunc
+	if f.Not != nil {
+		not, err := f.Not.compile()
+		if err != nil {
+			return nil, err
+		}
+		evals = append(evals, func(values map[string]interface{}) (truth, error) {
+			t, err := not(values)
+			return t.not(), err
+		})
+	}
+	if len(f.Or) > 0 {
+		var ors []evalFunc
+		for _, c := range f.Or {
+			e, err := c.compile()
+			if err != nil {
+				return nil, err
+			}
+			ors = append(ors, e)
+		}
+		evals = append(evals, func(values map[string]interface{}) (truth, error) {
+			result := isFalse
+			for _, e := range ors {
+				t, err := e(values)
+				if err != nil {
+					return isFalse, err
+				}
+				if result = result.or(t); result == isTrue {
+					break
+				}
+			}
+			return result, nil
+		})
+	}
+	for _, c := range f.And {
+		e, err := c.compile()
+		if err != nil {
+			return nil, err
+		}
+		evals = append(evals, e)
+	}
+	for _, a := range atoms(f) {
+		e, err := a.compileAtom()
+		if err != nil {
+			return nil, err
+		}
+		evals = append(evals, e)
+	}
+	if len(evals) == 0 {
+		return nil, fmt.Errorf("empty predicate FluxFilter")
+	}
+	return allOf(evals), nil
+}
+
+// compileAtom compiles a single predicate, see atoms.
+func (f *FluxFilter) compileAtom() (evalFunc, error) {
+	switch {
+	case f.Measurement != nil:
+		return equals("_measurement", *f.Measurement, true), nil
+	case f.MeasurementNEQ != nil:
+		return equals("_measurement", *f.MeasurementNEQ, false), nil
+	case f.MeasurementMatch != nil:
+		return matches("_measurement", *f.MeasurementMatch, true)
+	case f.MeasurementNMatch != nil:
+		return matches("_measurement", *f.MeasurementNMatch, false)
+	case f.MeasurementIn != nil:
+		return member("_measurement", f.MeasurementIn, true)
+	case f.MeasurementNotIn != nil:
+		return member("_measurement", f.MeasurementNotIn, false)
+	case f.Field != nil:
+		return equals("_field", *f.Field, true), nil
+	case f.FieldNEQ != nil:
+		return equals("_field", *f.FieldNEQ, false), nil
+	case f.FieldMatch != nil:
+		return matches("_field", *f.FieldMatch, true)
+	case f.FieldNMatch != nil:
+		return matches("_field", *f.FieldNMatch, false)
+	case f.FieldIn != nil:
+		return member("_field", f.FieldIn, true)
+	case f.FieldNotIn != nil:
+		return member("_field", f.FieldNotIn, false)
+	case len(f.Tags) == 1:
+		return f.Tags[0].compile()
+	case len(f.Compare) == 1:
+		return f.Compare[0].compile()
+	}
+	return nil, fmt.Errorf("expr predicates cannot be evaluated")
+}
+
+// stringColumn reads a string column, which is null when it is missing.
+func stringColumn(values map[string]interface{}, column string) (string, bool, error) {
+	v := values[column]
+	if v == nil {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf("column %s is %T, not a string", column, v)
+	}
+	return s, true, nil
+}
+
+func equals(column, value string, eq bool) evalFunc {
+	return func(values map[string]interface{}) (truth, error) {
+		s, ok, err := stringColumn(values, column)
+		if !ok {
+			return isNull, err
+		}
+		return truthOf((s == value) == eq), nil
+	}
+}
+
+func matches(column string, re Regex, eq bool) (evalFunc, error) {
+	compiled, err := re.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return func(values map[string]interface{}) (truth, error) {
+		s, ok, err := stringColumn(values, column)
+		if !ok {
+			return isNull, err
+		}
+		return truthOf(compiled.MatchString(s) == eq), nil
+	}, nil
+}
+
+func member(column string, set []string, in bool) (evalFunc, error) {
+	if len(set) == 0 {
+		return nil, fmt.Errorf("empty set for %s", column)
+	}
+	values := make(map[string]bool, len(set))
+	for _, v := range set {
+		values[v] = true
+	}
+	return func(record map[string]interface{}) (truth, error) {
+		s, ok, err := stringColumn(record, column)
+		if !ok {
+			return isNull, err
+		}
+		return truthOf(values[s] == in), nil
+	}, nil
+}
+
+func (t *TagPredicate) compile() (evalFunc, error) {
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	switch t.Op {
+	case TagEq, TagNEQ:
+		return equals(t.Key, t.Value, t.Op == TagEq), nil
+	case TagMatch, TagNMatch:
+		return matches(t.Key, t.Regex, t.Op == TagMatch)
+	case TagIn, TagNotIn:
+		return member(t.Key, t.Values, t.Op == TagIn)
+	}
+	want, key := t.Op == TagExists, t.Key
+	return func(values map[string]interface{}) (truth, error) {
+		return truthOf((values[key] != nil) == want), nil
+	}, nil
+}
+
+func (c *Comparison) compile() (evalFunc, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	column := c.column()
+	v, _ := c.Value.normalize()
+	var to interface{}
+	if c.To != nil {
+		to, _ = c.To.normalize()
+	}
+	return func(values map[string]interface{}) (truth, error) {
+		x := values[column]
+		if x == nil {
+			return isNull, nil
+		}
+		cmp, err := compareValues(x, v)
+		if err != nil {
+			return isFalse, fmt.Errorf("column %s: %w", column, err)
+		}
+		switch c.Op {
+		case OpEq:
+			return truthOf(cmp == 0), nil
+		case OpNEQ:
+			return truthOf(cmp != 0), nil
+		case OpLT:
+			return truthOf(cmp < 0), nil
+		case OpLTE:
+			return truthOf(cmp <= 0), nil
+		case OpGT:
+			return truthOf(cmp > 0), nil
+		case OpGTE:
+			return truthOf(cmp >= 0), nil
+		}
+		upper, err := compareValues(x, to)
+		if err != nil {
+			return isFalse, fmt.Errorf("column %s: %w", column, err)
+		}
+		return truthOf(cmp >= 0 && upper <= 0), nil
+	}, nil
+}
+
+// compareValues orders a column value and an operand. Numbers of different
+// types compare by value as in Flux, other types must match. Bools are
+// only compared for equality, which Validate ensures.
+func compareValues(x, v interface{}) (int, error) {
+	switch a := toNumber(x).(type) {
+	case int64, uint64, float64:
+		b := toNumber(v)
+		switch b.(type) {
+		case int64, uint64, float64:
+			return compareNumbers(a, b), nil
+		}
+	case string:
+		if b, ok := v.(string); ok {
+			switch {
+			case a < b:
+				return -1, nil
+			case a > b:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case bool:
+		if b, ok := v.(bool); ok {
+			if a == b {
+				return 0, nil
+			}
+			return 1, nil
+		}
+	case time.Time:
+		if b, ok := v.(time.Time); ok {
+			return a.Compare(b), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", x, v)
+}
+
+// toNumber converts the Go number types into int64, uint64 or float64.
+func toNumber(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int8:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case uint:
+		return uint64(n)
+	case uint8:
+		return uint64(n)
+	case uint16:
+		return uint64(n)
+	case uint32:
+		return uint64(n)
+	case float32:
+		return float64(n)
+	}
+	return v
+}
+
+func compareNumbers(a, b interface{}) int {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return order(x < y, x > y)
+		case uint64:
+			if x < 0 {
+				return -1
+			}
+			return order(uint64(x) < y, uint64(x) > y)
+		}
+	case uint64:
+		switch y := b.(type) {
+		case uint64:
+			return order(x < y, x > y)
+		case int64:
+			return -compareNumbers(y, x)
+		}
+	}
+	fa, fb := toFloat(a), toFloat(b)
+	return order(fa < fb, fa > fb)
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int64:
+		return float64(n)
+	case uint64:
+		return float64(n)
+	}
+	return v.(float64)
+}
+
+func order(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+func TestPredicate_Match(t *testing.T) {
+	f, err := Parse(`measurement = "cpu" AND (host =~ /^gw-/ OR host IN ("a", "b")) AND value > 10 AND NOT exists debug AND site != "lab"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Compile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		values  map[string]interface{}
+		matches bool
+	}{
+		{map[string]interface{}{"_measurement": "cpu", "host": "gw-1", "_value": 10.5, "site": "hq"}, true},
+		{map[string]interface{}{"_measurement": "cpu", "host": "b", "_value": uint64(11), "site": "hq"}, true},
+		{map[string]interface{}{"_measurement": "cpu", "host": "c", "_value": 11, "site": "hq"}, false},
+		{map[string]interface{}{"_measurement": "cpu", "host": "a", "_value": int64(10), "site": "hq"}, false},
+		{map[string]interface{}{"_measurement": "cpu", "host": "a", "_value": 11.0, "site": "hq", "debug": "1"}, false},
+		// r.site != "lab" is null without a site, as in Flux
+		{map[string]interface{}{"_measurement": "cpu", "host": "a", "_value": 11.0}, false},
+	} {
+		m, err := p.Match(c.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != c.matches {
+			t.Errorf("%v: expected %t", c.values, c.matches)
+		}
+	}
+
+	// not of a null predicate stays null
+	not := &FluxFilter{Not: &FluxFilter{Tags: []*TagPredicate{{Key: "host", Op: TagEq, Value: "a"}}}}
+	if m, _ := not.Match(map[string]interface{}{}); m {
+		t.Error("expected no match for a missing tag")
+	}
+
+	point := write.NewPoint("cpu", map[string]string{"host": "gw-1", "site": "hq"}, map[string]interface{}{"usage": 42.0, "idle": 5.0}, time.Now())
+	var matched []string
+	for _, r := range PointRecords(point) {
+		if m, err := p.Match(r); err != nil {
+			t.Fatal(err)
+		} else if m {
+			matched = append(matched, r["_field"].(string))
+		}
+	}
+	if strings.Join(matched, ",") != "usage" {
+		t.Errorf("unexpected fields: %v", matched)
+	}
+
+	if _, err := p.Match(map[string]interface{}{"_measurement": "cpu", "host": "a", "_value": "11", "site": "hq"}); err == nil {
+		t.Error("expected an error for a string compared with a number")
+	}
+	if _, err := Compile(&FluxFilter{Expr: expression.Raw("true")}); err == nil {
+		t.Error("expected an error for an Expr predicate")
+	}
+}
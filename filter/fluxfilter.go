@@ -1,33 +1,95 @@
 package filter
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/ThinkontrolSY/flux-builder/literal"
 )
 
 type FluxFilter struct {
-	Not *FluxFilter
-	Or  []*FluxFilter
-	And []*FluxFilter
-
-	Measurement       *string
-	MeasurementNEQ    *string
-	MeasurementMatch  *string
-	MeasurementNMatch *string
-
-	Field       *string
-	FieldNEQ    *string
-	FieldMatch  *string
-	FieldNMatch *string
-
-	TagKey    *string
-	Tag       *string
-	TagNEQ    *string
-	TagMatch  *string
-	TagNMatch *string
-	TagExists *bool
-
-	Value *string
+	Not *FluxFilter   `json:"not,omitempty"`
+	Or  []*FluxFilter `json:"or,omitempty"`
+	And []*FluxFilter `json:"and,omitempty"`
+
+	// The In and NotIn fields test the membership in a set of values, see
+	// ContainsThreshold.
+	Measurement       *string  `json:"measurement,omitempty"`
+	MeasurementNEQ    *string  `json:"measurementNEQ,omitempty"`
+	MeasurementMatch  *Regex   `json:"measurementMatch,omitempty"`
+	MeasurementNMatch *Regex   `json:"measurementNMatch,omitempty"`
+	MeasurementIn     []string `json:"measurementIn,omitempty"`
+	MeasurementNotIn  []string `json:"measurementNotIn,omitempty"`
+
+	Field       *string  `json:"field,omitempty"`
+	FieldNEQ    *string  `json:"fieldNEQ,omitempty"`
+	FieldMatch  *Regex   `json:"fieldMatch,omitempty"`
+	FieldNMatch *Regex   `json:"fieldNMatch,omitempty"`
+	FieldIn     []string `json:"fieldIn,omitempty"`
+	FieldNotIn  []string `json:"fieldNotIn,omitempty"`
+
+	// Tags holds the predicates on tags. The former single tag fields,
+	// tagKey with tag, tagNEQ, tagMatch, tagNMatch, tagExists, tagIn and
+	// tagNotIn, are still read from JSON.
+	Tags []*TagPredicate `json:"tags,omitempty"`
+
+	// Compare holds typed comparisons on any column. It replaces the raw
+	// value predicate, which is still read from JSON as a comparison on
+	// _value.
+	Compare []*Comparison `json:"compare,omitempty"`
+
+	// Expr is an arbitrary predicate over r for what the fields above cannot
+	// express, e.g. strings.hasPrefix(v: r.host, prefix: "gw-"). In JSON it
+	// is stored as its Flux source and read back as expression.Raw.
+	Expr expression.Expr `json:"-"`
+}
+
+// fluxFilterJSON is FluxFilter without its JSON methods.
+type fluxFilterJSON FluxFilter
+
+func (f *FluxFilter) MarshalJSON() ([]byte, error) {
+	var expr *string
+	if f.Expr != nil {
+		s, err := f.Expr.Expr()
+		if err != nil {
+			return nil, err
+		}
+		expr = &s
+	}
+	return json.Marshal(struct {
+		*fluxFilterJSON
+		Expr *string `json:"expr,omitempty"`
+	}{(*fluxFilterJSON)(f), expr})
+}
+
+func (f *FluxFilter) UnmarshalJSON(data []byte) error {
+	v := struct {
+		*fluxFilterJSON
+		legacyTagFields
+		Expr  *string `json:"expr,omitempty"`
+		Value *string `json:"value,omitempty"`
+	}{fluxFilterJSON: (*fluxFilterJSON)(f)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	tags, err := legacyTags(&v.legacyTagFields)
+	if err != nil {
+		return err
+	}
+	f.Tags = append(f.Tags, tags...)
+	if v.Expr != nil {
+		f.Expr = expression.Raw(*v.Expr)
+	}
+	if v.Value != nil {
+		c, err := legacyValue(*v.Value)
+		if err != nil {
+			return err
+		}
+		f.Compare = append(f.Compare, c)
+	}
+	return nil
 }
 
 func (f *FluxFilter) AddNot(n *FluxFilter) {
@@ -42,20 +104,23 @@ func (f *FluxFilter) AddAnd(n *FluxFilter) {
 	f.And = append(f.And, n)
 }
 
-func (f *FluxFilter) p() (string, error) {
+func (f *FluxFilter) p(b *literal.Binder) (string, error) {
 	var equations []string
 
 	if f.Not != nil {
-		p, err := f.Not.p()
+		p, err := f.Not.p(b)
 		if err != nil {
 			return "", err
 		}
-		equations = append(equations, fmt.Sprintf("not (%s)", p))
+		if !f.Not.enclosed() {
+			p = "(" + p + ")"
+		}
+		equations = append(equations, "not "+p)
 	}
 
 	switch n := len(f.Or); {
 	case n == 1:
-		p, err := f.Or[0].p()
+		p, err := f.Or[0].p(b)
 		if err != nil {
 			return "", err
 		}
@@ -63,7 +128,7 @@ func (f *FluxFilter) p() (string, error) {
 	case n > 1:
 		or := make([]string, 0, n)
 		for _, w := range f.Or {
-			p, err := w.p()
+			p, err := w.p(b)
 			if err != nil {
 				return "", err
 			}
@@ -74,7 +139,7 @@ func (f *FluxFilter) p() (string, error) {
 
 	switch n := len(f.And); {
 	case n == 1:
-		p, err := f.And[0].p()
+		p, err := f.And[0].p(b)
 		if err != nil {
 			return "", err
 		}
@@ -82,71 +147,95 @@ func (f *FluxFilter) p() (string, error) {
 	case n > 1:
 		and := make([]string, 0, n)
 		for _, w := range f.And {
-			p, err := w.p()
+			p, err := w.p(b)
 			if err != nil {
 				return "", err
 			}
 			and = append(and, p)
 		}
-		equations = append(equations, fmt.Sprintf("(%s)", strings.Join(and, " and ")))
+		// and binds tighter than or and the equations are joined with and,
+		// so the group needs no parentheses
+		equations = append(equations, strings.Join(and, " and "))
 	}
 
 	if f.Measurement != nil {
-		equations = append(equations, fmt.Sprintf("r._measurement == \"%s\"", *f.Measurement))
+		equations = append(equations, fmt.Sprintf("r._measurement == %s", b.String(*f.Measurement)))
 	}
 
 	if f.MeasurementNEQ != nil {
-		equations = append(equations, fmt.Sprintf("r._measurement != \"%s\"", *f.MeasurementNEQ))
+		equations = append(equations, fmt.Sprintf("r._measurement != %s", b.String(*f.MeasurementNEQ)))
 	}
 
 	if f.MeasurementMatch != nil {
-		equations = append(equations, fmt.Sprintf("r._measurement =~ %s", *f.MeasurementMatch))
+		re, err := f.MeasurementMatch.Flux()
+		if err != nil {
+			return "", err
+		}
+		equations = append(equations, fmt.Sprintf("r._measurement =~ %s", re))
 	}
 
 	if f.MeasurementNMatch != nil {
-		equations = append(equations, fmt.Sprintf("r._measurement !~ %s", *f.MeasurementNMatch))
+		re, err := f.MeasurementNMatch.Flux()
+		if err != nil {
+			return "", err
+		}
+		equations = append(equations, fmt.Sprintf("r._measurement !~ %s", re))
+	}
+
+	if err := appendSets(&equations, b, "_measurement", f.MeasurementIn, f.MeasurementNotIn); err != nil {
+		return "", err
 	}
 
 	if f.Field != nil {
-		equations = append(equations, fmt.Sprintf("r._field == \"%s\"", *f.Field))
+		equations = append(equations, fmt.Sprintf("r._field == %s", b.String(*f.Field)))
 	}
 
 	if f.FieldNEQ != nil {
-		equations = append(equations, fmt.Sprintf("r._field != \"%s\"", *f.FieldNEQ))
+		equations = append(equations, fmt.Sprintf("r._field != %s", b.String(*f.FieldNEQ)))
 	}
 
 	if f.FieldMatch != nil {
-		equations = append(equations, fmt.Sprintf("r._field =~ %s", *f.FieldMatch))
+		re, err := f.FieldMatch.Flux()
+		if err != nil {
+			return "", err
+		}
+		equations = append(equations, fmt.Sprintf("r._field =~ %s", re))
 	}
 
 	if f.FieldNMatch != nil {
-		equations = append(equations, fmt.Sprintf("r._field !~ %s", *f.FieldNMatch))
+		re, err := f.FieldNMatch.Flux()
+		if err != nil {
+			return "", err
+		}
+		equations = append(equations, fmt.Sprintf("r._field !~ %s", re))
 	}
 
-	if f.TagKey != nil {
-		if f.Tag != nil {
-			equations = append(equations, fmt.Sprintf("r.%s == \"%s\"", *f.TagKey, *f.Tag))
-		}
-		if f.TagNEQ != nil {
-			equations = append(equations, fmt.Sprintf("r.%s != \"%s\"", *f.TagKey, *f.TagNEQ))
-		}
-		if f.TagMatch != nil {
-			equations = append(equations, fmt.Sprintf("r.%s =~ %s", *f.TagKey, *f.TagMatch))
-		}
-		if f.TagNMatch != nil {
-			equations = append(equations, fmt.Sprintf("r.%s !~ %s", *f.TagKey, *f.TagNMatch))
+	if err := appendSets(&equations, b, "_field", f.FieldIn, f.FieldNotIn); err != nil {
+		return "", err
+	}
+
+	for _, t := range f.Tags {
+		e, err := t.flux(b)
+		if err != nil {
+			return "", err
 		}
-		if f.TagExists != nil {
-			if *f.TagExists {
-				equations = append(equations, fmt.Sprintf("exists r.%s", *f.TagKey))
-			} else {
-				equations = append(equations, fmt.Sprintf("not exists r.%s", *f.TagKey))
-			}
+		equations = append(equations, e)
+	}
+
+	for _, c := range f.Compare {
+		e, err := c.flux(b)
+		if err != nil {
+			return "", err
 		}
+		equations = append(equations, e)
 	}
 
-	if f.Value != nil {
-		equations = append(equations, fmt.Sprintf("r._value %s", *f.Value))
+	if f.Expr != nil {
+		e, err := expression.Operand(f.Expr, expression.OpAnd)
+		if err != nil {
+			return "", err
+		}
+		equations = append(equations, e)
 	}
 
 	switch len(equations) {
@@ -159,10 +248,125 @@ func (f *FluxFilter) p() (string, error) {
 	}
 }
 
+// enclosed reports whether f renders as a single parenthesized group, an or
+// of several filters or a short set, which not wraps without parentheses of
+// its own.
+func (f *FluxFilter) enclosed() bool {
+	n := len(atoms(f)) + len(f.And)
+	if f.Not != nil {
+		n++
+	}
+	if len(f.Or) > 0 {
+		n++
+	}
+	if n != 1 {
+		return false
+	}
+	switch {
+	case len(f.Or) > 1:
+		return true
+	case len(f.Or) == 1:
+		return f.Or[0].enclosed()
+	case len(f.And) == 1:
+		return f.And[0].enclosed()
+	}
+	values := f.MeasurementIn
+	if f.FieldIn != nil {
+		values = f.FieldIn
+	}
+	if len(f.Tags) == 1 && f.Tags[0].Op == TagIn {
+		values = f.Tags[0].Values
+	}
+	return len(values) > 1 && len(values) < ContainsThreshold
+}
+
+// ContainsThreshold is the size from which sets render as contains() rather
+// than a chain of equalities. The storage pushes the chains down but not
+// contains(), which is cheaper to plan for large sets.
+const ContainsThreshold = 10
+
+func appendSets(equations *[]string, b *literal.Binder, column string, in, notIn []string) error {
+	for _, s := range []struct {
+		values []string
+		in     bool
+	}{{in, true}, {notIn, false}} {
+		if s.values == nil {
+			continue
+		}
+		e, err := set(b, column, s.values, s.in)
+		if err != nil {
+			return err
+		}
+		*equations = append(*equations, e)
+	}
+	return nil
+}
+
+// set renders the membership test of column in values.
+func set(b *literal.Binder, column string, values []string, in bool) (string, error) {
+	if len(values) == 0 {
+		return "", fmt.Errorf("empty set for %s", column)
+	}
+	ref := literal.Member("r", column)
+	elements := make([]string, 0, len(values))
+	for _, v := range values {
+		elements = append(elements, b.String(v))
+	}
+	if len(values) >= ContainsThreshold {
+		e := fmt.Sprintf("contains(value: %s, set: [%s])", ref, strings.Join(elements, ", "))
+		if !in {
+			e = "not " + e
+		}
+		return e, nil
+	}
+	op, join := "==", " or "
+	if !in {
+		op, join = "!=", " and "
+	}
+	for i, e := range elements {
+		elements[i] = fmt.Sprintf("%s %s %s", ref, op, e)
+	}
+	if in && len(elements) > 1 {
+		return fmt.Sprintf("(%s)", strings.Join(elements, join)), nil
+	}
+	return strings.Join(elements, join), nil
+}
+
 func (f *FluxFilter) Pipe() (string, error) {
-	p, err := f.p()
+	return f.BindPipe(nil)
+}
+
+// BindPipe renders the filter with its string values bound through b, see
+// literal.Binder.
+func (f *FluxFilter) BindPipe(b *literal.Binder) (string, error) {
+	p, err := f.p(b)
 	if err != nil {
 		return "", err
 	}
 	return fmt.Sprintf(`|> filter(fn: (r) => %s)`, p), nil
 }
+
+// Imports collects the packages required by the Expr predicates of the tree.
+func (f *FluxFilter) Imports() []string {
+	set := map[string]bool{}
+	f.imports(set)
+	return expression.Sorted(set)
+}
+
+func (f *FluxFilter) imports(set map[string]bool) {
+	if f == nil {
+		return
+	}
+	if f.Expr != nil {
+		for _, i := range expression.Imports(f.Expr) {
+			set[i] = true
+		}
+	}
+	f.Not.imports(set)
+	for _, o := range f.Or {
+		o.imports(set)
+	}
+	for _, a := range f.And {
+		a.imports(set)
+	}
+}
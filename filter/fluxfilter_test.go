@@ -0,0 +1,32 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFluxFilter_Sets(t *testing.T) {
+	var sensors []string
+	for i := 0; i < 12; i++ {
+		sensors = append(sensors, fmt.Sprintf("s%d", i))
+	}
+	var f FluxFilter
+	data := `{"measurementIn": ["cpu", "mem"], "fieldNotIn": ["idle"], "tagKey": "sensor", "tagIn": ["` + strings.Join(sensors, `", "`) + `"]}`
+	if err := json.Unmarshal([]byte(data), &f); err != nil {
+		t.Fatal(err)
+	}
+	s, err := f.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `|> filter(fn: (r) => (r._measurement == "cpu" or r._measurement == "mem") and r._field != "idle" and contains(value: r.sensor, set: ["s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11"]))`
+	if s != expected {
+		t.Errorf("unexpected filter:\n%s", s)
+	}
+
+	if _, err := (&FluxFilter{MeasurementIn: []string{}}).Pipe(); err == nil {
+		t.Error("expected an error for an empty set")
+	}
+}
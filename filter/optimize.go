@@ -0,0 +1,421 @@
+package filter
+
+import (
+	"sort"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+)
+
+// Optimize merges the filters into a single equivalent filter that is
+// cheaper to plan and keeps the predicates InfluxDB pushes down to the
+// storage in front:
+//
+//   - the filters are merged into one and nested and/or groups are flattened
+//   - not is pushed inward with De Morgan's laws, so that not (a == x)
+//     becomes a != x
+//   - duplicate predicates are removed
+//   - equalities on one column joined by or are collapsed into a set, see
+//     ContainsThreshold, and so are inequalities joined by and
+//   - the predicates of a conjunction are ordered measurement, field, tags,
+//     then the predicates the storage cannot evaluate
+//
+// The filters are not modified. Optimize returns nil when there is no filter.
+func Optimize(filters ...*FluxFilter) *FluxFilter {
+	t := &term{op: opAnd}
+	for _, f := range filters {
+		if f != nil {
+			t.terms = append(t.terms, build(f))
+		}
+	}
+	t = simplify(push(t, false))
+	if t.op == opAnd && len(t.terms) == 0 {
+		return nil
+	}
+	return t.filter()
+}
+
+const (
+	opAtom = iota
+	opAnd
+	opOr
+	opNot
+)
+
+// term is the boolean tree of a filter. Atoms are FluxFilters with a single
+// predicate.
+type term struct {
+	op    int
+	terms []*term
+	atom  *FluxFilter
+}
+
+func build(f *FluxFilter) *term {
+	t := &term{op: opAnd}
+	if f.Not != nil {
+		t.terms = append(t.terms, &term{op: opNot, terms: []*term{build(f.Not)}})
+	}
+	if len(f.Or) > 0 {
+		or := &term{op: opOr}
+		for _, c := range f.Or {
+			if c != nil {
+				or.terms = append(or.terms, build(c))
+			}
+		}
+		// an or of nil children is dropped like the nil children themselves
+		if len(or.terms) > 0 {
+			t.terms = append(t.terms, or)
+		}
+	}
+	for _, c := range f.And {
+		if c != nil {
+			t.terms = append(t.terms, build(c))
+		}
+	}
+	for _, a := range atoms(f) {
+		t.terms = append(t.terms, &term{op: opAtom, atom: a})
+	}
+	return t
+}
+
+// atoms splits the predicates of a node, not its children.
+func atoms(f *FluxFilter) []*FluxFilter {
+	var out []*FluxFilter
+	str := func(set func(a *FluxFilter, v *string), v *string) {
+		if v != nil {
+			a := &FluxFilter{}
+			set(a, v)
+			out = append(out, a)
+		}
+	}
+	re := func(set func(a *FluxFilter, v *Regex), v *Regex) {
+		if v != nil {
+			a := &FluxFilter{}
+			set(a, v)
+			out = append(out, a)
+		}
+	}
+	str(func(a *FluxFilter, v *string) { a.Measurement = v }, f.Measurement)
+	str(func(a *FluxFilter, v *string) { a.MeasurementNEQ = v }, f.MeasurementNEQ)
+	re(func(a *FluxFilter, v *Regex) { a.MeasurementMatch = v }, f.MeasurementMatch)
+	re(func(a *FluxFilter, v *Regex) { a.MeasurementNMatch = v }, f.MeasurementNMatch)
+	if f.MeasurementIn != nil {
+		out = append(out, &FluxFilter{MeasurementIn: f.MeasurementIn})
+	}
+	if f.MeasurementNotIn != nil {
+		out = append(out, &FluxFilter{MeasurementNotIn: f.MeasurementNotIn})
+	}
+	str(func(a *FluxFilter, v *string) { a.Field = v }, f.Field)
+	str(func(a *FluxFilter, v *string) { a.FieldNEQ = v }, f.FieldNEQ)
+	re(func(a *FluxFilter, v *Regex) { a.FieldMatch = v }, f.FieldMatch)
+	re(func(a *FluxFilter, v *Regex) { a.FieldNMatch = v }, f.FieldNMatch)
+	if f.FieldIn != nil {
+		out = append(out, &FluxFilter{FieldIn: f.FieldIn})
+	}
+	if f.FieldNotIn != nil {
+		out = append(out, &FluxFilter{FieldNotIn: f.FieldNotIn})
+	}
+	for _, t := range f.Tags {
+		out = append(out, &FluxFilter{Tags: []*TagPredicate{t}})
+	}
+	for _, c := range f.Compare {
+		out = append(out, &FluxFilter{Compare: []*Comparison{c}})
+	}
+	if f.Expr != nil {
+		out = append(out, &FluxFilter{Expr: f.Expr})
+	}
+	return out
+}
+
+// negate returns the atom that matches when a does not, if there is one.
+func negate(a *FluxFilter) (*FluxFilter, bool) {
+	switch {
+	case a.Measurement != nil:
+		return &FluxFilter{MeasurementNEQ: a.Measurement}, true
+	case a.MeasurementNEQ != nil:
+		return &FluxFilter{Measurement: a.MeasurementNEQ}, true
+	case a.MeasurementMatch != nil:
+		return &FluxFilter{MeasurementNMatch: a.MeasurementMatch}, true
+	case a.MeasurementNMatch != nil:
+		return &FluxFilter{MeasurementMatch: a.MeasurementNMatch}, true
+	case a.Field != nil:
+		return &FluxFilter{FieldNEQ: a.Field}, true
+	case a.FieldNEQ != nil:
+		return &FluxFilter{Field: a.FieldNEQ}, true
+	case a.FieldMatch != nil:
+		return &FluxFilter{FieldNMatch: a.FieldMatch}, true
+	case a.FieldNMatch != nil:
+		return &FluxFilter{FieldMatch: a.FieldNMatch}, true
+	case a.MeasurementIn != nil:
+		return &FluxFilter{MeasurementNotIn: a.MeasurementIn}, true
+	case a.MeasurementNotIn != nil:
+		return &FluxFilter{MeasurementIn: a.MeasurementNotIn}, true
+	case a.FieldIn != nil:
+		return &FluxFilter{FieldNotIn: a.FieldIn}, true
+	case a.FieldNotIn != nil:
+		return &FluxFilter{FieldIn: a.FieldNotIn}, true
+	case len(a.Tags) == 1:
+		return &FluxFilter{Tags: []*TagPredicate{a.Tags[0].negate()}}, true
+	case a.Expr != nil:
+		if u, ok := a.Expr.(*expression.Unary); ok && u.Op == expression.OpNot {
+			return &FluxFilter{Expr: u.Operand}, true
+		}
+		return &FluxFilter{Expr: expression.Not(a.Expr)}, true
+	}
+	return nil, false
+}
+
+// push moves not inward with De Morgan's laws.
+func push(t *term, negated bool) *term {
+	switch t.op {
+	case opNot:
+		return push(t.terms[0], !negated)
+	case opAtom:
+		if !negated {
+			return t
+		}
+		if c := t.atom.Compare; len(c) == 1 {
+			// not between is one of two comparisons
+			if cs := c[0].negate(); len(cs) > 0 {
+				or := &term{op: opOr}
+				for _, n := range cs {
+					or.terms = append(or.terms, &term{op: opAtom, atom: &FluxFilter{Compare: []*Comparison{n}}})
+				}
+				return simplify(or)
+			}
+		}
+		if n, ok := negate(t.atom); ok {
+			return &term{op: opAtom, atom: n}
+		}
+		return &term{op: opNot, terms: []*term{t}}
+	}
+	op := t.op
+	if negated {
+		if op == opAnd {
+			op = opOr
+		} else {
+			op = opAnd
+		}
+	}
+	out := &term{op: op}
+	for _, c := range t.terms {
+		out.terms = append(out.terms, push(c, negated))
+	}
+	return out
+}
+
+// simplify flattens, deduplicates, collapses and orders the tree.
+func simplify(t *term) *term {
+	if t.op == opAtom || t.op == opNot {
+		return t
+	}
+	var terms []*term
+	seen := map[string]bool{}
+	var add func(c *term)
+	add = func(c *term) {
+		c = simplify(c)
+		if c.op == t.op {
+			for _, g := range c.terms {
+				add(g)
+			}
+			return
+		}
+		if k := c.key(); k != "" {
+			if seen[k] {
+				return
+			}
+			seen[k] = true
+		}
+		terms = append(terms, c)
+	}
+	for _, c := range t.terms {
+		add(c)
+	}
+	terms = collapse(t.op, terms)
+	if t.op == opAnd {
+		sort.SliceStable(terms, func(i, j int) bool { return terms[i].rank() < terms[j].rank() })
+	}
+	if len(terms) == 1 {
+		return terms[0]
+	}
+	return &term{op: t.op, terms: terms}
+}
+
+// key identifies atoms for deduplication. Composite terms are not compared.
+func (t *term) key() string {
+	if t.op != opAtom {
+		return ""
+	}
+	s, err := t.atom.p(nil)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// members returns the column and the values of an == or in atom, or of a
+// != or not in atom when neq is set.
+func members(t *term, neq bool) (string, []string, bool) {
+	if t.op != opAtom {
+		return "", nil, false
+	}
+	a := t.atom
+	one := func(column string, v *string) (string, []string, bool) {
+		return column, []string{*v}, true
+	}
+	if !neq {
+		switch {
+		case a.Measurement != nil:
+			return one("_measurement", a.Measurement)
+		case a.MeasurementIn != nil:
+			return "_measurement", a.MeasurementIn, true
+		case a.Field != nil:
+			return one("_field", a.Field)
+		case a.FieldIn != nil:
+			return "_field", a.FieldIn, true
+		case tag(a, TagEq) != nil:
+			return one(a.Tags[0].Key, &a.Tags[0].Value)
+		case tag(a, TagIn) != nil:
+			return a.Tags[0].Key, a.Tags[0].Values, true
+		}
+		return "", nil, false
+	}
+	switch {
+	case a.MeasurementNEQ != nil:
+		return one("_measurement", a.MeasurementNEQ)
+	case a.MeasurementNotIn != nil:
+		return "_measurement", a.MeasurementNotIn, true
+	case a.FieldNEQ != nil:
+		return one("_field", a.FieldNEQ)
+	case a.FieldNotIn != nil:
+		return "_field", a.FieldNotIn, true
+	case tag(a, TagNEQ) != nil:
+		return one(a.Tags[0].Key, &a.Tags[0].Value)
+	case tag(a, TagNotIn) != nil:
+		return a.Tags[0].Key, a.Tags[0].Values, true
+	}
+	return "", nil, false
+}
+
+// collapse merges the equalities on one column joined by or, and the
+// inequalities joined by and, into a set.
+func collapse(op int, terms []*term) []*term {
+	neq := op == opAnd
+	values := map[string][]string{}
+	count := map[string]int{}
+	for _, t := range terms {
+		if column, vs, ok := members(t, neq); ok {
+			values[column] = appendUnique(values[column], vs...)
+			count[column]++
+		}
+	}
+	var out []*term
+	done := map[string]bool{}
+	for _, t := range terms {
+		column, _, ok := members(t, neq)
+		if !ok || count[column] < 2 {
+			out = append(out, t)
+			continue
+		}
+		if done[column] {
+			continue
+		}
+		done[column] = true
+		out = append(out, &term{op: opAtom, atom: setAtom(column, values[column], !neq)})
+	}
+	return out
+}
+
+func appendUnique(values []string, vs ...string) []string {
+	for _, v := range vs {
+		found := false
+		for _, w := range values {
+			found = found || w == v
+		}
+		if !found {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func setAtom(column string, values []string, in bool) *FluxFilter {
+	switch {
+	case column == "_measurement" && in:
+		return &FluxFilter{MeasurementIn: values}
+	case column == "_measurement":
+		return &FluxFilter{MeasurementNotIn: values}
+	case column == "_field" && in:
+		return &FluxFilter{FieldIn: values}
+	case column == "_field":
+		return &FluxFilter{FieldNotIn: values}
+	case in:
+		return &FluxFilter{Tags: []*TagPredicate{{Key: column, Op: TagIn, Values: values}}}
+	}
+	return &FluxFilter{Tags: []*TagPredicate{{Key: column, Op: TagNotIn, Values: values}}}
+}
+
+// tag returns the tag predicate of an atom if it has the operator op.
+func tag(a *FluxFilter, op TagOp) *TagPredicate {
+	if len(a.Tags) == 1 && a.Tags[0].Op == op {
+		return a.Tags[0]
+	}
+	return nil
+}
+
+// rank orders the predicates of a conjunction, those the storage pushes
+// down first.
+func (t *term) rank() int {
+	switch t.op {
+	case opNot:
+		return 9
+	case opAnd, opOr:
+		r := 0
+		for _, c := range t.terms {
+			if cr := c.rank(); cr > r {
+				r = cr
+			}
+		}
+		return r
+	}
+	a := t.atom
+	// large sets render as contains(), which is not pushed down
+	small := func(values []string) bool { return len(values) < ContainsThreshold }
+	switch {
+	case a.Measurement != nil, small(a.MeasurementIn) && a.MeasurementIn != nil:
+		return 0
+	case a.Field != nil, small(a.FieldIn) && a.FieldIn != nil:
+		return 1
+	case tag(a, TagEq) != nil, tag(a, TagIn) != nil && small(a.Tags[0].Values):
+		return 2
+	case a.MeasurementNEQ != nil, a.FieldNEQ != nil, tag(a, TagNEQ) != nil,
+		small(a.MeasurementNotIn) && a.MeasurementNotIn != nil, small(a.FieldNotIn) && a.FieldNotIn != nil,
+		tag(a, TagNotIn) != nil && small(a.Tags[0].Values):
+		return 3
+	case a.MeasurementMatch != nil, a.MeasurementNMatch != nil, a.FieldMatch != nil, a.FieldNMatch != nil,
+		tag(a, TagMatch) != nil, tag(a, TagNMatch) != nil:
+		return 4
+	case tag(a, TagExists) != nil, tag(a, TagNotExists) != nil:
+		return 5
+	case a.Compare != nil:
+		return 7
+	}
+	return 8
+}
+
+func (t *term) filter() *FluxFilter {
+	switch t.op {
+	case opAtom:
+		a := *t.atom
+		return &a
+	case opNot:
+		return &FluxFilter{Not: t.terms[0].filter()}
+	}
+	children := make([]*FluxFilter, 0, len(t.terms))
+	for _, c := range t.terms {
+		children = append(children, c.filter())
+	}
+	if t.op == opOr {
+		return &FluxFilter{Or: children}
+	}
+	return &FluxFilter{And: children}
+}
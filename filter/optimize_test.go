@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestOptimize(t *testing.T) {
+	m, usage, idle := "cpu", "usage", "idle"
+	cases := []struct {
+		filters  []*FluxFilter
+		expected string
+	}{
+		// not (idle or not (host == "a" and cpu)) is pushed down to the atoms
+		{
+			[]*FluxFilter{{Not: &FluxFilter{Or: []*FluxFilter{
+				{Field: &idle},
+				{Not: &FluxFilter{And: []*FluxFilter{tagFilter(&TagPredicate{Key: "host", Op: TagEq, Value: "a"}), {Measurement: &m}}}},
+			}}}},
+			`r._measurement == "cpu" and r.host == "a" and r._field != "idle"`,
+		},
+		{
+			[]*FluxFilter{{Not: &FluxFilter{Not: &FluxFilter{Compare: []*Comparison{{Op: OpGT, Value: IntValue(1)}}}}}},
+			`r._value > 1`,
+		},
+		{
+			[]*FluxFilter{{Field: &usage}, {And: []*FluxFilter{{Measurement: &m}, {Field: &usage}}}, {Measurement: &m}},
+			`r._measurement == "cpu" and r._field == "usage"`,
+		},
+		{
+			[]*FluxFilter{{Not: &FluxFilter{Or: []*FluxFilter{{Field: &usage}, {Field: &idle}, {Field: &usage}}}}},
+			`r._field != "usage" and r._field != "idle"`,
+		},
+		{
+			[]*FluxFilter{{Or: []*FluxFilter{nil, nil}, Field: &usage}},
+			`r._field == "usage"`,
+		},
+	}
+	for _, c := range cases {
+		s, err := Optimize(c.filters...).Pipe()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if expected := fmt.Sprintf("|> filter(fn: (r) => %s)", c.expected); s != expected {
+			t.Errorf("expected %s, got %s", expected, s)
+		}
+	}
+
+	if f := Optimize(&FluxFilter{Or: []*FluxFilter{nil, nil}}); f != nil {
+		t.Errorf("expected no filter, got %+v", f)
+	}
+
+	// equalities joined by or collapse into a set, which renders as
+	// contains() from ContainsThreshold values on
+	for _, n := range []int{ContainsThreshold - 1, ContainsThreshold} {
+		var hosts []*FluxFilter
+		for i := 0; i < n; i++ {
+			hosts = append(hosts, tagFilter(&TagPredicate{Key: "host", Op: TagEq, Value: fmt.Sprintf("h%d", i)}))
+		}
+		f := Optimize(&FluxFilter{Or: hosts})
+		if len(f.Tags) != 1 || f.Tags[0].Op != TagIn || len(f.Tags[0].Values) != n {
+			t.Fatalf("expected a set of %d hosts, got %+v", n, f)
+		}
+		s, err := f.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contains := strings.HasPrefix(s, "|> filter(fn: (r) => contains(value: r.host"); contains != (n >= ContainsThreshold) {
+			t.Errorf("unexpected filter for %d hosts: %s", n, s)
+		}
+	}
+}
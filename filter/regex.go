@@ -0,0 +1,126 @@
+package filter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/literal"
+)
+
+// Regex is the pattern of a *Match field in the RE2 syntax of Go, which
+// Flux uses. It is stored without the slashes of the Flux literal, values
+// wrapped in slashes such as /gw-.*/ are read as the pattern between them.
+type Regex string
+
+// NewRegex checks pattern and returns it as a Regex.
+func NewRegex(pattern string) (Regex, error) {
+	r := Regex(Regex(pattern).Pattern())
+	return r, r.Validate()
+}
+
+// MustRegex is like NewRegex but panics on an invalid pattern, to define
+// patterns in variables.
+func MustRegex(pattern string) Regex {
+	r, err := NewRegex(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// QuoteRegex returns a Regex that matches s literally anywhere in a value.
+func QuoteRegex(s string) Regex {
+	return Regex(quote(s))
+}
+
+// PrefixRegex returns a Regex that matches the values starting with s.
+func PrefixRegex(s string) Regex {
+	return Regex("^" + quote(s))
+}
+
+// ExactRegex returns a Regex that matches s only, e.g. to combine with
+// IgnoreCase.
+func ExactRegex(s string) Regex {
+	return Regex("^" + quote(s) + "$")
+}
+
+// quote escapes the metacharacters of s and its slashes, so that a quoted
+// /api/ is not read as a pattern wrapped in slashes.
+func quote(s string) string {
+	return strings.ReplaceAll(regexp.QuoteMeta(s), "/", `\/`)
+}
+
+// Pattern returns the RE2 pattern without the slashes.
+func (r Regex) Pattern() string {
+	s := string(r)
+	if len(s) >= 2 && strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") {
+		// the closing slash must not be escaped itself
+		escapes := len(s[:len(s)-1]) - len(strings.TrimRight(s[:len(s)-1], `\`))
+		if escapes%2 == 0 {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// IgnoreCase returns the pattern matching case-insensitively.
+func (r Regex) IgnoreCase() Regex {
+	p := r.Pattern()
+	if strings.HasPrefix(p, "(?i)") {
+		return Regex(p)
+	}
+	return Regex("(?i)" + p)
+}
+
+// Validate checks that the pattern compiles with RE2 and can be written as
+// a Flux regex literal.
+func (r Regex) Validate() error {
+	p := r.Pattern()
+	if p == "" {
+		// the literal // starts a comment in Flux
+		return fmt.Errorf("empty regex")
+	}
+	if _, err := syntax.Parse(p, syntax.Perl); err != nil {
+		var se *syntax.Error
+		if errors.As(err, &se) && (se.Code == syntax.ErrInvalidPerlOp || se.Code == syntax.ErrInvalidEscape) {
+			return fmt.Errorf("invalid regex: %w, RE2 supports neither lookarounds nor backreferences", err)
+		}
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+	return nil
+}
+
+// Compile compiles the pattern for matching in Go.
+func (r Regex) Compile() (*regexp.Regexp, error) {
+	if err := r.Validate(); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(r.Pattern())
+}
+
+// Flux renders the pattern as a Flux regex literal, escaping the slashes.
+func (r Regex) Flux() (string, error) {
+	if err := r.Validate(); err != nil {
+		return "", err
+	}
+	return literal.Regex(r.Pattern())
+}
+
+// UnmarshalJSON checks the pattern when it is decoded, rather than when the
+// query is rendered.
+func (r *Regex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := NewRegex(s)
+	if err != nil {
+		return err
+	}
+	*r = v
+	return nil
+}
@@ -0,0 +1,43 @@
+package filter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRegex(t *testing.T) {
+	path, host := PrefixRegex("/var/log"), ExactRegex("gw.1").IgnoreCase()
+	legacy := Regex("/^cpu$/")
+	s, err := (&FluxFilter{MeasurementMatch: &legacy, FieldMatch: &path, Tags: []*TagPredicate{{Key: "host", Op: TagMatch, Regex: host}}}).Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != `|> filter(fn: (r) => r._measurement =~ /^cpu$/ and r._field =~ /^\/var\/log/ and r.host =~ /(?i)^gw\.1$/)` {
+		t.Errorf("unexpected filter:\n%s", s)
+	}
+
+	for _, pattern := range []string{"", "//", "(", `a(?=b)`, `(a)\1`} {
+		if _, err := NewRegex(pattern); err == nil {
+			t.Errorf("expected an error for %q", pattern)
+		}
+	}
+	api := QuoteRegex("/api/")
+	if m, _ := (&FluxFilter{FieldMatch: &api}).Match(map[string]interface{}{"_field": "GET /api/v1"}); !m {
+		t.Error("expected a literal match")
+	}
+	if _, err := NewRegex(`a(?=b)`); err == nil || !strings.Contains(err.Error(), "lookarounds") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"tagKey": "host", "tagMatch": "gw-("}`), new(FluxFilter)); err == nil {
+		t.Error("expected an error for an invalid regex in JSON")
+	}
+
+	f, err := Parse(`host =~ /GW-\d+/i`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := f.Match(map[string]interface{}{"host": "gw-12"}); !m {
+		t.Error("expected a case-insensitive match")
+	}
+}
@@ -0,0 +1,136 @@
+package filter
+
+import (
+	"fmt"
+
+	"github.com/ThinkontrolSY/flux-builder/literal"
+)
+
+type TagOp string
+
+const (
+	TagEq        TagOp = "=="
+	TagNEQ       TagOp = "!="
+	TagMatch     TagOp = "=~"
+	TagNMatch    TagOp = "!~"
+	TagIn        TagOp = "in"
+	TagNotIn     TagOp = "notIn"
+	TagExists    TagOp = "exists"
+	TagNotExists TagOp = "notExists"
+)
+
+// TagPredicate is a condition on the tag Key. Value is the operand of == and
+// !=, Regex of =~ and !~ and Values of in and notIn, see ContainsThreshold.
+type TagPredicate struct {
+	Key    string   `json:"key"`
+	Op     TagOp    `json:"op"`
+	Value  string   `json:"value,omitempty"`
+	Regex  Regex    `json:"regex,omitempty"`
+	Values []string `json:"values,omitempty"`
+}
+
+// Validate checks that the predicate has a key and the operand of its
+// operator.
+func (t *TagPredicate) Validate() error {
+	if t.Key == "" {
+		return fmt.Errorf("tag operator %s has no key", t.Op)
+	}
+	switch t.Op {
+	case TagEq, TagNEQ, TagExists, TagNotExists:
+		return nil
+	case TagMatch, TagNMatch:
+		return t.Regex.Validate()
+	case TagIn, TagNotIn:
+		if len(t.Values) == 0 {
+			return fmt.Errorf("empty set for %s", t.Key)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid tag operator: %q", t.Op)
+}
+
+func (t *TagPredicate) flux(b *literal.Binder) (string, error) {
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+	tag := literal.Member("r", t.Key)
+	switch t.Op {
+	case TagEq, TagNEQ:
+		return fmt.Sprintf("%s %s %s", tag, t.Op, b.String(t.Value)), nil
+	case TagMatch, TagNMatch:
+		re, err := t.Regex.Flux()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s %s %s", tag, t.Op, re), nil
+	case TagExists:
+		return fmt.Sprintf("exists %s", tag), nil
+	case TagNotExists:
+		return fmt.Sprintf("not exists %s", tag), nil
+	}
+	return set(b, t.Key, t.Values, t.Op == TagIn)
+}
+
+// negate returns the predicate that matches when t does not.
+func (t *TagPredicate) negate() *TagPredicate {
+	inverse := map[TagOp]TagOp{
+		TagEq: TagNEQ, TagNEQ: TagEq, TagMatch: TagNMatch, TagNMatch: TagMatch,
+		TagIn: TagNotIn, TagNotIn: TagIn, TagExists: TagNotExists, TagNotExists: TagExists,
+	}
+	n := *t
+	n.Op = inverse[t.Op]
+	return &n
+}
+
+// legacyTags reads the former tag fields, which hold the predicates on the
+// single tag TagKey.
+func legacyTags(v *legacyTagFields) ([]*TagPredicate, error) {
+	var tags []*TagPredicate
+	add := func(t *TagPredicate) {
+		if v.TagKey != nil {
+			t.Key = *v.TagKey
+		}
+		tags = append(tags, t)
+	}
+	if v.Tag != nil {
+		add(&TagPredicate{Op: TagEq, Value: *v.Tag})
+	}
+	if v.TagNEQ != nil {
+		add(&TagPredicate{Op: TagNEQ, Value: *v.TagNEQ})
+	}
+	if v.TagMatch != nil {
+		add(&TagPredicate{Op: TagMatch, Regex: *v.TagMatch})
+	}
+	if v.TagNMatch != nil {
+		add(&TagPredicate{Op: TagNMatch, Regex: *v.TagNMatch})
+	}
+	if v.TagExists != nil && *v.TagExists {
+		add(&TagPredicate{Op: TagExists})
+	}
+	if v.TagExists != nil && !*v.TagExists {
+		add(&TagPredicate{Op: TagNotExists})
+	}
+	if v.TagIn != nil {
+		add(&TagPredicate{Op: TagIn, Values: v.TagIn})
+	}
+	if v.TagNotIn != nil {
+		add(&TagPredicate{Op: TagNotIn, Values: v.TagNotIn})
+	}
+	for _, t := range tags {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+type legacyTagFields struct {
+	TagKey    *string  `json:"tagKey,omitempty"`
+	Tag       *string  `json:"tag,omitempty"`
+	TagNEQ    *string  `json:"tagNEQ,omitempty"`
+	TagMatch  *Regex   `json:"tagMatch,omitempty"`
+	TagNMatch *Regex   `json:"tagNMatch,omitempty"`
+	TagExists *bool    `json:"tagExists,omitempty"`
+	TagIn     []string `json:"tagIn,omitempty"`
+	TagNotIn  []string `json:"tagNotIn,omitempty"`
+}
@@ -0,0 +1,53 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTagPredicate(t *testing.T) {
+	f := &FluxFilter{Tags: []*TagPredicate{
+		{Key: "host", Op: TagMatch, Regex: "^gw-"},
+		{Key: "site", Op: TagNEQ, Value: "lab"},
+		{Key: "rack", Op: TagNotExists},
+	}}
+	s, err := f.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `|> filter(fn: (r) => r.host =~ /^gw-/ and r.site != "lab" and not exists r.rack)`
+	if s != expected {
+		t.Errorf("unexpected filter:\n%s", s)
+	}
+
+	var legacy []*FluxFilter
+	if err := json.Unmarshal([]byte(`[
+		{"tagKey": "host", "tagMatch": "/^gw-/", "tags": [{"key": "site", "op": "!=", "value": "lab"}]},
+		{"tagKey": "rack", "tagExists": false}
+	]`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := Optimize(legacy...).Pipe(); s != `|> filter(fn: (r) => r.site != "lab" and r.host =~ /^gw-/ and not exists r.rack)` {
+		t.Errorf("unexpected legacy filter: %s", s)
+	}
+	data, err := json.Marshal(legacy[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"tags":[{"key":"rack","op":"notExists"}]}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	if err := json.Unmarshal([]byte(`{"tag": "a"}`), new(FluxFilter)); err == nil {
+		t.Error("expected an error for a tag predicate without tagKey")
+	}
+	for _, p := range []*TagPredicate{
+		{Op: TagEq, Value: "a"},
+		{Key: "host", Op: "~"},
+		{Key: "host", Op: TagIn},
+	} {
+		if _, err := (&FluxFilter{Tags: []*TagPredicate{p}}).Pipe(); err == nil {
+			t.Errorf("expected an error for %+v", p)
+		}
+	}
+}
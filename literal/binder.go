@@ -0,0 +1,44 @@
+package literal
+
+import (
+	"fmt"
+	"time"
+)
+
+// Binder renders string and time values as references into the params extern of a
+// query request instead of inlining them, so that one script text serves
+// every value. A nil Binder inlines values as literals.
+type Binder struct {
+	params map[string]interface{}
+	n      int
+}
+
+// NewBinder binds values into params, next to the names already present.
+func NewBinder(params map[string]interface{}) *Binder {
+	return &Binder{params: params}
+}
+
+func (b *Binder) String(s string) string {
+	if b == nil {
+		return String(s)
+	}
+	return b.bind(s)
+}
+
+func (b *Binder) bind(v interface{}) string {
+	for {
+		name := fmt.Sprintf("p%d", b.n)
+		b.n++
+		if _, ok := b.params[name]; !ok {
+			b.params[name] = v
+			return Member("params", name)
+		}
+	}
+}
+
+func (b *Binder) Time(t time.Time) string {
+	if b == nil {
+		return Time(t)
+	}
+	return fmt.Sprintf("time(v: %s)", b.bind(t.UTC()))
+}
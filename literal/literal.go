@@ -0,0 +1,183 @@
+package literal
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var durationRegexp = regexp.MustCompile(`^(-)?(\d+(ns|us|ms|s|m|h|d|w|mo|y))+$`)
+
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "exists": true, "empty": true,
+	"import": true, "package": true, "option": true, "builtin": true, "testing": true,
+	"if": true, "then": true, "else": true, "return": true,
+}
+
+// IsIdentifier reports whether name can be used as a bare Flux identifier.
+func IsIdentifier(name string) bool {
+	return identifierRegexp.MatchString(name) && !keywords[name]
+}
+
+// Identifier checks that name can be used as a variable, parameter or
+// property name without quoting.
+func Identifier(name string) error {
+	if !IsIdentifier(name) {
+		return fmt.Errorf("invalid identifier: %q", name)
+	}
+	return nil
+}
+
+// Member renders the access of key on the record obj: r._value, or
+// r["my-tag"] when key is not a valid identifier.
+func Member(obj, key string) string {
+	if IsIdentifier(key) {
+		return fmt.Sprintf("%s.%s", obj, key)
+	}
+	return fmt.Sprintf("%s[%s]", obj, String(key))
+}
+
+// String renders a Flux string literal. Unlike Go, Flux interpolates ${...}
+// inside strings, so the dollar sign has to be escaped as well.
+func String(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Strings renders an array of string literals.
+func Strings(ss []string) string {
+	quoted := make([]string, 0, len(ss))
+	for _, s := range ss {
+		quoted = append(quoted, String(s))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(quoted, ", "))
+}
+
+// Regex renders a regular expression literal. The pattern is checked with
+// Go's RE2, the engine Flux uses, and unescaped slashes are escaped.
+func Regex(pattern string) (string, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return "", fmt.Errorf("invalid regex: %w", err)
+	}
+	var b strings.Builder
+	b.WriteByte('/')
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			b.WriteByte(c)
+			if i+1 < len(pattern) {
+				i++
+				b.WriteByte(pattern[i])
+			}
+		case '/':
+			b.WriteString(`\/`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('/')
+	return b.String(), nil
+}
+
+// Duration checks and renders a duration literal such as 1h30m or -1mo.
+func Duration(d string) (string, error) {
+	if !durationRegexp.MatchString(d) {
+		return "", fmt.Errorf("invalid duration value: %s", d)
+	}
+	return d, nil
+}
+
+// Time renders a date and time literal.
+func Time(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func Int(i int64) string {
+	return strconv.FormatInt(i, 10)
+}
+
+func UInt(u uint64) string {
+	return fmt.Sprintf("uint(v: %d)", u)
+}
+
+func Float(f float64) (string, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return "", fmt.Errorf("unsupported float value: %v", f)
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s, nil
+}
+
+func Bool(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+// Value renders a Go value of a basic type as the matching Flux literal.
+func Value(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return String(v), nil
+	case bool:
+		return Bool(v), nil
+	case int:
+		return Int(int64(v)), nil
+	case int8:
+		return Int(int64(v)), nil
+	case int16:
+		return Int(int64(v)), nil
+	case int32:
+		return Int(int64(v)), nil
+	case int64:
+		return Int(v), nil
+	case uint:
+		return UInt(uint64(v)), nil
+	case uint8:
+		return UInt(uint64(v)), nil
+	case uint16:
+		return UInt(uint64(v)), nil
+	case uint32:
+		return UInt(uint64(v)), nil
+	case uint64:
+		return UInt(v), nil
+	case float32:
+		return Float(float64(v))
+	case float64:
+		return Float(v)
+	case time.Time:
+		return Time(v), nil
+	default:
+		return "", fmt.Errorf("unsupported value type: %T", v)
+	}
+}
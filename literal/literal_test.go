@@ -0,0 +1,85 @@
+package literal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestString(t *testing.T) {
+	cases := map[string]string{
+		`cpu`:              `"cpu"`,
+		`a"b`:              `"a\"b"`,
+		`C:\temp`:          `"C:\\temp"`,
+		"line\nbreak":      `"line\nbreak"`,
+		`${r._value}`:      `"\${r._value}"`,
+		`") or true or ("`: `"\") or true or (\""`,
+		`cost $5`:          `"cost $5"`,
+	}
+	for in, expected := range cases {
+		if s := String(in); s != expected {
+			t.Errorf("String(%q) = %s, expected %s", in, s, expected)
+		}
+	}
+}
+
+func TestMember(t *testing.T) {
+	cases := map[string]string{
+		"_value":  `r._value`,
+		"my-tag":  `r["my-tag"]`,
+		"or":      `r["or"]`,
+		`a"b`:     `r["a\"b"]`,
+		"2nd":     `r["2nd"]`,
+		"sensor1": `r.sensor1`,
+	}
+	for in, expected := range cases {
+		if s := Member("r", in); s != expected {
+			t.Errorf("Member(%q) = %s, expected %s", in, s, expected)
+		}
+	}
+}
+
+func TestRegex(t *testing.T) {
+	cases := map[string]string{
+		`gw-.*`:       `/gw-.*/`,
+		`^/var/log`:   `/^\/var\/log/`,
+		`^\/var\/log`: `/^\/var\/log/`,
+		`a\\`:         `/a\\/`,
+	}
+	for in, expected := range cases {
+		s, err := Regex(in)
+		if err != nil {
+			t.Error(err)
+		} else if s != expected {
+			t.Errorf("Regex(%q) = %s, expected %s", in, s, expected)
+		}
+	}
+	if _, err := Regex(`(`); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
+
+func TestValue(t *testing.T) {
+	cases := []struct {
+		in       interface{}
+		expected string
+	}{
+		{"a", `"a"`},
+		{1, `1`},
+		{uint(1), `uint(v: 1)`},
+		{1.5, `1.5`},
+		{2.0, `2.0`},
+		{true, `true`},
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), `2024-01-01T00:00:00Z`},
+	}
+	for _, c := range cases {
+		s, err := Value(c.in)
+		if err != nil {
+			t.Error(err)
+		} else if s != c.expected {
+			t.Errorf("Value(%v) = %s, expected %s", c.in, s, c.expected)
+		}
+	}
+	if _, err := Value([]int{1}); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
@@ -0,0 +1,468 @@
+package query
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+// MeasurementSchema lists the fields and tag keys of a measurement, as
+// returned by client.Schema.
+type MeasurementSchema struct {
+	Measurement string   `json:"measurement"`
+	Fields      []string `json:"fields"`
+	Tags        []string `json:"tags"`
+}
+
+// TableSchema is the shape of the tables at the end of a query.
+type TableSchema struct {
+	Columns  []string `json:"columns"`
+	GroupKey []string `json:"groupKey"`
+	// Open reports that the tables may have columns Analyze does not know,
+	// e.g. tags of measurements missing from the schema or columns created
+	// by pivot.
+	Open bool `json:"open"`
+}
+
+// tableState tracks the columns of the tables through the pipeline. Columns
+// in removed are known to be gone even while the state is open.
+type tableState struct {
+	columns  map[string]bool
+	groupKey map[string]bool
+	removed  map[string]bool
+	open     bool
+	// openKey reports that the unknown columns are part of the group key.
+	openKey bool
+	// unknown is set after a pipe Analyze cannot reason about, which stops
+	// the checks.
+	unknown bool
+}
+
+func newTableState(columns ...string) *tableState {
+	s := &tableState{columns: map[string]bool{}, groupKey: map[string]bool{}, removed: map[string]bool{}}
+	for _, c := range columns {
+		s.columns[c] = true
+	}
+	return s
+}
+
+func (s *tableState) has(column string) bool {
+	if s.columns[column] || s.unknown {
+		return true
+	}
+	return s.open && !s.removed[column] && !strings.HasPrefix(column, "_")
+}
+
+func (s *tableState) add(columns ...string) {
+	for _, c := range columns {
+		s.columns[c] = true
+		delete(s.removed, c)
+	}
+}
+
+func (s *tableState) drop(columns ...string) {
+	for _, c := range columns {
+		delete(s.columns, c)
+		delete(s.groupKey, c)
+		s.removed[c] = true
+	}
+}
+
+// reduce keeps only the group key and the given columns, as aggregates do.
+func (s *tableState) reduce(columns ...string) {
+	var gone []string
+	for c := range s.columns {
+		if !s.groupKey[c] {
+			gone = append(gone, c)
+		}
+	}
+	s.drop(gone...)
+	s.add(columns...)
+	s.open = s.openKey
+}
+
+func (s *tableState) schema() *TableSchema {
+	return &TableSchema{Columns: sortedKeys(s.columns), GroupKey: sortedKeys(s.groupKey), Open: s.open || s.unknown}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Analyze infers the columns and the group key of the tables the query
+// returns, starting from the measurements of the bucket, and reports the
+// transforms that read columns which no longer exist, e.g. mean() after
+// drop(columns: ["_value"]). Without a schema the tags are unknown and only
+// the well known columns are checked.
+//
+// The errors are pipe.ParamErrors. Errors of the filters have index -1.
+func (q *FluxQuery) Analyze(schema []*MeasurementSchema) (*TableSchema, error) {
+	var errs pipe.ParamErrors
+	s := q.source(schema, &errs)
+	for i, t := range q.Transforms {
+		for _, e := range s.apply(t) {
+			e.Index = i
+			e.Fn = pipe.NameOf(t)
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return s.schema(), errs
+	}
+	return s.schema(), nil
+}
+
+// source is the state after from, range and the filters.
+func (q *FluxQuery) source(schema []*MeasurementSchema, errs *pipe.ParamErrors) *tableState {
+	s := newTableState("_start", "_stop", "_time", "_value", "_measurement", "_field")
+	for _, c := range []string{"_start", "_stop", "_measurement", "_field"} {
+		s.groupKey[c] = true
+	}
+	if len(schema) == 0 {
+		s.open, s.openKey = true, true
+		return s
+	}
+
+	measurements := map[string]*MeasurementSchema{}
+	for _, m := range schema {
+		measurements[m.Measurement] = m
+	}
+	var names, fields map[string]bool
+	for _, f := range q.Filters {
+		if m, ok := constraint(f, func(f *filter.FluxFilter) []string { return values(f.Measurement, f.MeasurementIn) }); ok {
+			names = intersect(names, m)
+		}
+		if m, ok := constraint(f, func(f *filter.FluxFilter) []string { return values(f.Field, f.FieldIn) }); ok {
+			fields = intersect(fields, m)
+		}
+	}
+
+	matched := schema
+	if names != nil {
+		matched = nil
+		for _, name := range sortedKeys(names) {
+			m, ok := measurements[name]
+			if !ok {
+				*errs = append(*errs, &pipe.ParamError{Index: -1, Param: "filters", Message: "measurement " + name + " is not in the schema"})
+				continue
+			}
+			matched = append(matched, m)
+		}
+	}
+	known := map[string]bool{}
+	for _, m := range matched {
+		for _, f := range m.Fields {
+			known[f] = true
+		}
+		for _, t := range m.Tags {
+			s.add(t)
+			s.groupKey[t] = true
+		}
+	}
+	for _, f := range sortedKeys(fields) {
+		if names != nil && len(matched) > 0 && !known[f] {
+			*errs = append(*errs, &pipe.ParamError{Index: -1, Param: "filters", Message: "field " + f + " is not in the measurements " + measurementNames(matched)})
+		}
+	}
+	return s
+}
+
+func measurementNames(schema []*MeasurementSchema) string {
+	names := make([]string, 0, len(schema))
+	for _, m := range schema {
+		names = append(names, m.Measurement)
+	}
+	return strings.Join(names, ", ")
+}
+
+// constraint returns the values a filter allows for the column read by get,
+// e.g. the measurements of r._measurement == "cpu" or r._measurement == "mem".
+// It reports false when the filter does not restrict the column.
+func constraint(f *filter.FluxFilter, get func(*filter.FluxFilter) []string) (map[string]bool, bool) {
+	var set map[string]bool
+	restricted := false
+	if vs := get(f); vs != nil {
+		set, restricted = map[string]bool{}, true
+		for _, v := range vs {
+			set[v] = true
+		}
+	}
+	if len(f.Or) > 0 {
+		union := map[string]bool{}
+		all := true
+		for _, o := range f.Or {
+			m, ok := constraint(o, get)
+			if !ok {
+				all = false
+				break
+			}
+			for v := range m {
+				union[v] = true
+			}
+		}
+		if all {
+			set, restricted = intersect(set, union), true
+		}
+	}
+	for _, a := range f.And {
+		if m, ok := constraint(a, get); ok {
+			set, restricted = intersect(set, m), true
+		}
+	}
+	return set, restricted
+}
+
+// values returns the allowed values of an equality and a set, nil when
+// neither is set.
+func values(eq *string, in []string) []string {
+	if eq == nil {
+		return in
+	}
+	if in == nil {
+		return []string{*eq}
+	}
+	for _, v := range in {
+		if v == *eq {
+			return []string{v}
+		}
+	}
+	return []string{}
+}
+
+// intersect treats a nil set as unrestricted.
+func intersect(a, b map[string]bool) map[string]bool {
+	if a == nil {
+		return b
+	}
+	out := map[string]bool{}
+	for v := range a {
+		if b[v] {
+			out[v] = true
+		}
+	}
+	return out
+}
+
+func or(s *string, def string) string {
+	if s != nil {
+		return *s
+	}
+	return def
+}
+
+func orColumns(columns []string, def ...string) []string {
+	if len(columns) > 0 {
+		return columns
+	}
+	return def
+}
+
+// require reports the columns that do not exist.
+func (s *tableState) require(errs *pipe.ParamErrors, param string, columns ...string) {
+	for _, c := range columns {
+		if !s.has(c) {
+			*errs = append(*errs, &pipe.ParamError{Param: param, Message: "column " + c + " does not exist"})
+		}
+	}
+}
+
+// apply updates the state with the effect of t and returns the problems
+// found.
+func (s *tableState) apply(t pipe.TransformPipe) pipe.ParamErrors {
+	var errs pipe.ParamErrors
+	switch a := t.(type) {
+	case *pipe.KeepPipe:
+		var gone []string
+		keep := map[string]bool{}
+		for _, c := range a.Columns {
+			keep[c] = true
+			if s.has(c) {
+				s.add(c)
+			}
+		}
+		for c := range s.columns {
+			if !keep[c] {
+				gone = append(gone, c)
+			}
+		}
+		s.drop(gone...)
+		s.open, s.openKey = false, false
+	case *pipe.DropPipe:
+		s.drop(a.Columns...)
+	case *pipe.GroupPipe:
+		s.groupKey = map[string]bool{}
+		if a.Mode != nil && *a.Mode == "except" {
+			except := map[string]bool{}
+			for _, c := range a.Columns {
+				except[c] = true
+			}
+			for c := range s.columns {
+				if !except[c] {
+					s.groupKey[c] = true
+				}
+			}
+			s.openKey = s.open
+		} else {
+			s.openKey = false
+			for _, c := range a.Columns {
+				if s.has(c) {
+					s.add(c)
+					s.groupKey[c] = true
+				}
+			}
+		}
+	case *pipe.AggregatorPipe:
+		column := or(a.Column, "_value")
+		s.require(&errs, "column", column)
+		s.require(&errs, "timeSrc", or(a.TimeSrc, "_stop"))
+		s.reduce(column, or(a.TimeDst, "_time"))
+	case *pipe.WindowPipe:
+		s.require(&errs, "timeColumn", or(a.TimeColumn, "_time"))
+		start, stop := or(a.StartColumn, "_start"), or(a.StopColumn, "_stop")
+		s.add(start, stop)
+		s.groupKey[start], s.groupKey[stop] = true, true
+	case *pipe.CountPipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.MeanPipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.SumPipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.SkewPipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.SpreadPipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.StddevPipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.ModePipe:
+		s.aggregate(&errs, a.Column)
+	case *pipe.MedianPipe:
+		if a.Method != nil && *a.Method == pipe.EstimateSelector {
+			s.require(&errs, "column", or(a.Column, "_value"))
+		} else {
+			s.aggregate(&errs, a.Column)
+		}
+	case *pipe.QuantilePipe:
+		if a.Method != nil && *a.Method == pipe.EstimateSelector {
+			s.require(&errs, "column", or(a.Column, "_value"))
+		} else {
+			s.aggregate(&errs, a.Column)
+		}
+	case *pipe.IntegralPipe:
+		s.require(&errs, "timeColumn", or(a.TimeColumn, "_time"))
+		s.aggregate(&errs, a.Column)
+	case *pipe.TimeWeightedAvgPipe:
+		s.require(&errs, "", "_time")
+		s.aggregate(&errs, nil)
+	case *pipe.DistinctPipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+		s.reduce("_value")
+	case *pipe.UniquePipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+	case *pipe.FirstPipe, *pipe.LastPipe:
+		s.require(&errs, "", "_value")
+	case *pipe.MinPipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+	case *pipe.MaxPipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+	case *pipe.TopPipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.BottomPipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.SortPipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.CumulativeSumPipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.IncreasePipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.DifferencePipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.DerivativePipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+		s.require(&errs, "timeColumn", or(a.TimeColumn, "_time"))
+	case *pipe.RelativeStrengthIndexPipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_value")...)
+	case *pipe.KaufmansAMAPipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+	case *pipe.TimeMovingAveragePipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+		s.require(&errs, "", "_time")
+	case *pipe.MovingAveragePipe, *pipe.ExponentialMovingAveragePipe, *pipe.DoubleEMAPipe, *pipe.TripleEMAPipe,
+		*pipe.TripleExponentialDerivativePipe, *pipe.KaufmansERPipe,
+		*pipe.ToBoolPipe, *pipe.ToFloatPipe, *pipe.ToIntPipe, *pipe.ToStringPipe, *pipe.ToTimePipe, *pipe.ToUIntPipe:
+		s.require(&errs, "", "_value")
+	case *pipe.FillPipe:
+		s.require(&errs, "column", or(a.Column, "_value"))
+	case *pipe.ElapsedPipe:
+		s.require(&errs, "timeColumn", or(a.TimeColumn, "_time"))
+		s.add(or(a.ColumnName, "elapsed"))
+	case *pipe.StateCountPipe:
+		s.add(or(a.Column, "stateCount"))
+	case *pipe.StateDurationPipe:
+		s.require(&errs, "", "_time")
+		s.add(or(a.Column, "stateDuration"))
+	case *pipe.StateTrackingPipe:
+		if a.CountColumn != nil {
+			s.add(*a.CountColumn)
+		}
+		if a.DurationColumn != nil {
+			s.require(&errs, "", "_time")
+			s.add(*a.DurationColumn)
+		}
+	case *pipe.TruncateTimeColumnPipe:
+		s.require(&errs, "", "_time")
+	case *pipe.TimeShiftPipe:
+		s.require(&errs, "columns", orColumns(a.Columns, "_start", "_stop", "_time")...)
+	case *pipe.PivotPipe:
+		s.pivot(&errs, a.RowKey, a.ColumnKey, a.ValueColumn)
+	case *pipe.FieldsAsColsPipe:
+		s.pivot(&errs, []string{"_time"}, []string{"_field"}, "_value")
+	case *pipe.MapPipe:
+		if a.Replace {
+			// map keeps the group key even when it replaces the record
+			s.reduce()
+		}
+		for _, f := range a.Fields {
+			s.add(f.Column)
+		}
+	case *pipe.FilterPipe, *pipe.LimitPipe, *pipe.TailPipe, *pipe.YieldPipe:
+	default:
+		s.unknown = true
+	}
+	return errs
+}
+
+// aggregate reduces each table to a single row of the group key and the
+// aggregated column.
+func (s *tableState) aggregate(errs *pipe.ParamErrors, column *string) {
+	c := or(column, "_value")
+	s.require(errs, "column", c)
+	s.reduce(c)
+}
+
+func (s *tableState) pivot(errs *pipe.ParamErrors, rowKey, columnKey []string, valueColumn string) {
+	s.require(errs, "rowKey", rowKey...)
+	s.require(errs, "columnKey", columnKey...)
+	s.require(errs, "valueColumn", valueColumn)
+	keep := map[string]bool{}
+	for _, c := range rowKey {
+		keep[c] = true
+	}
+	var gone []string
+	for c := range s.columns {
+		if !keep[c] && !s.groupKey[c] {
+			gone = append(gone, c)
+		}
+	}
+	s.drop(gone...)
+	s.drop(columnKey...)
+	s.drop(valueColumn)
+	// the new columns are named after the values of the column key
+	s.open = true
+}
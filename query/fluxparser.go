@@ -0,0 +1,802 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file holds a small Flux lexer and parser covering the subset of the
+// language FluxQuery produces: imports, options, pipe chains, lambdas and
+// the expressions used inside them.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokFloat
+	tokDuration
+	tokTime
+	tokRegex
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value is the decoded content of strings and regexes
+	value string
+	pos   int
+}
+
+var (
+	timeToken     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2}))?`)
+	durationToken = regexp.MustCompile(`^(\d+(mo|ms|ns|us|µs|s|m|h|d|w|y))+`)
+	floatToken    = regexp.MustCompile(`^\d+\.\d+`)
+	intToken      = regexp.MustCompile(`^\d+`)
+	identToken    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+)
+
+var puncts = []string{
+	"|>", "=>", "==", "!=", "<=", ">=", "=~", "!~",
+	"(", ")", "[", "]", "{", "}", ",", ":", ".", "=", "<", ">", "+", "-", "*", "/", "%", "^",
+}
+
+type syntaxError struct {
+	pos int
+	msg string
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	// a slash starts a regex unless it follows an operand
+	regexAllowed := func() bool {
+		if len(tokens) == 0 {
+			return true
+		}
+		last := tokens[len(tokens)-1]
+		switch last.kind {
+		case tokIdent, tokString, tokInt, tokFloat, tokDuration, tokTime, tokRegex:
+			return false
+		case tokPunct:
+			return last.text != ")" && last.text != "]" && last.text != "}"
+		}
+		return true
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			continue
+		case c == '"':
+			value, n, err := lexString(src[i:])
+			if err != nil {
+				return nil, positionError(src, i, err.Error())
+			}
+			tokens = append(tokens, token{kind: tokString, text: src[i : i+n], value: value, pos: i})
+			i += n
+			continue
+		case c == '/' && regexAllowed():
+			j := i + 1
+			for ; j < len(src) && src[j] != '/'; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					break
+				}
+			}
+			if j >= len(src) || src[j] != '/' {
+				return nil, positionError(src, i, "unterminated regex")
+			}
+			tokens = append(tokens, token{kind: tokRegex, text: src[i : j+1], value: src[i+1 : j], pos: i})
+			i = j + 1
+			continue
+		case c >= '0' && c <= '9':
+			rest := src[i:]
+			for _, m := range []struct {
+				re   *regexp.Regexp
+				kind tokenKind
+			}{{timeToken, tokTime}, {durationToken, tokDuration}, {floatToken, tokFloat}, {intToken, tokInt}} {
+				if s := m.re.FindString(rest); s != "" {
+					tokens = append(tokens, token{kind: m.kind, text: s, pos: i})
+					i += len(s)
+					break
+				}
+			}
+			continue
+		}
+		if s := identToken.FindString(src[i:]); s != "" {
+			tokens = append(tokens, token{kind: tokIdent, text: s, pos: i})
+			i += len(s)
+			continue
+		}
+		matched := false
+		for _, p := range puncts {
+			if strings.HasPrefix(src[i:], p) {
+				tokens = append(tokens, token{kind: tokPunct, text: p, pos: i})
+				i += len(p)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, positionError(src, i, fmt.Sprintf("unexpected character %q", c))
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+func lexString(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(s[i])
+			case 'x':
+				if i+2 >= len(s) {
+					return "", 0, fmt.Errorf("invalid escape sequence")
+				}
+				v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid escape sequence")
+				}
+				b.WriteByte(byte(v))
+				i += 2
+			default:
+				return "", 0, fmt.Errorf("invalid escape sequence \\%c", s[i])
+			}
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				return "", 0, fmt.Errorf("string interpolation is not supported")
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// position converts a byte offset into a line:column pair.
+func position(src string, pos int) string {
+	if pos > len(src) {
+		pos = len(src)
+	}
+	line := strings.Count(src[:pos], "\n") + 1
+	col := pos - strings.LastIndex(src[:pos], "\n")
+	return fmt.Sprintf("%d:%d", line, col)
+}
+
+func positionError(src string, pos int, msg string) error {
+	return fmt.Errorf("%s: %s", position(src, pos), msg)
+}
+
+// syntax tree
+
+type node interface {
+	position() int
+}
+
+type at int
+
+func (a at) position() int { return int(a) }
+
+type identNode struct {
+	at
+	name string
+}
+
+type memberNode struct {
+	at
+	object   node
+	property string
+}
+
+type stringNode struct {
+	at
+	value string
+}
+
+type intNode struct {
+	at
+	value int64
+}
+
+type floatNode struct {
+	at
+	value float64
+}
+
+type durationNode struct {
+	at
+	value string
+}
+
+type timeNode struct {
+	at
+	value time.Time
+}
+
+type regexNode struct {
+	at
+	pattern string
+}
+
+type arrayNode struct {
+	at
+	elements []node
+}
+
+type propertyNode struct {
+	key   string
+	value node
+}
+
+type recordNode struct {
+	at
+	with       node
+	properties []propertyNode
+}
+
+type argNode struct {
+	name  string
+	value node
+}
+
+type callNode struct {
+	at
+	callee node
+	args   []argNode
+}
+
+type pipeNode struct {
+	at
+	arg  node
+	call *callNode
+}
+
+type binaryNode struct {
+	at
+	op    string
+	left  node
+	right node
+}
+
+type unaryNode struct {
+	at
+	op      string
+	operand node
+}
+
+type conditionalNode struct {
+	at
+	test, consequent, alternate node
+}
+
+type functionNode struct {
+	at
+	params []string
+	body   node
+	// src is the source text of the whole function
+	src string
+}
+
+type statement struct {
+	pos int
+	// kind is import, option, assign or expression
+	kind  string
+	name  string
+	value node
+	path  string
+}
+
+type fluxParser struct {
+	src    string
+	tokens []token
+	i      int
+}
+
+func parseFlux(src string) ([]statement, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &fluxParser{src: src, tokens: tokens}
+	var statements []statement
+	for p.peek().kind != tokEOF {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+func (p *fluxParser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *fluxParser) peekAt(n int) token {
+	if p.i+n < len(p.tokens) {
+		return p.tokens[p.i+n]
+	}
+	return p.tokens[len(p.tokens)-1]
+}
+
+func (p *fluxParser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *fluxParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokIdent) && t.text == text
+}
+
+func (p *fluxParser) expect(text string) (token, error) {
+	t := p.next()
+	if (t.kind != tokPunct && t.kind != tokIdent) || t.text != text {
+		return t, p.errorf(t, "expected %q", text)
+	}
+	return t, nil
+}
+
+func (p *fluxParser) errorf(t token, format string, args ...interface{}) error {
+	found := t.text
+	if t.kind == tokEOF {
+		found = "end of script"
+	}
+	return positionError(p.src, t.pos, fmt.Sprintf(format, args...)+fmt.Sprintf(", found %s", found))
+}
+
+func (p *fluxParser) statement() (statement, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokIdent && t.text == "import":
+		p.next()
+		path := p.next()
+		if path.kind != tokString {
+			return statement{}, p.errorf(path, "expected import path")
+		}
+		return statement{pos: t.pos, kind: "import", path: path.value}, nil
+	case t.kind == tokIdent && t.text == "option":
+		p.next()
+		name, err := p.dotted()
+		if err != nil {
+			return statement{}, err
+		}
+		if _, err := p.expect("="); err != nil {
+			return statement{}, err
+		}
+		value, err := p.expr()
+		if err != nil {
+			return statement{}, err
+		}
+		return statement{pos: t.pos, kind: "option", name: name, value: value}, nil
+	case t.kind == tokIdent && p.peekAt(1).kind == tokPunct && p.peekAt(1).text == "=":
+		p.next()
+		p.next()
+		value, err := p.expr()
+		if err != nil {
+			return statement{}, err
+		}
+		return statement{pos: t.pos, kind: "assign", name: t.text, value: value}, nil
+	}
+	value, err := p.expr()
+	if err != nil {
+		return statement{}, err
+	}
+	return statement{pos: t.pos, kind: "expression", value: value}, nil
+}
+
+func (p *fluxParser) dotted() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", p.errorf(t, "expected identifier")
+	}
+	name := t.text
+	for p.is(".") {
+		p.next()
+		t = p.next()
+		if t.kind != tokIdent {
+			return "", p.errorf(t, "expected identifier")
+		}
+		name += "." + t.text
+	}
+	return name, nil
+}
+
+func (p *fluxParser) expr() (node, error) {
+	if p.is("if") {
+		t := p.next()
+		test, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		consequent, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect("else"); err != nil {
+			return nil, err
+		}
+		alternate, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionalNode{at: at(t.pos), test: test, consequent: consequent, alternate: alternate}, nil
+	}
+	return p.binary(0)
+}
+
+// binaryLevels lists the binary operators from the loosest to the tightest.
+var binaryLevels = [][]string{
+	{"or"},
+	{"and"},
+	nil, // not and exists
+	{"==", "!=", "<", "<=", ">", ">=", "=~", "!~"},
+	{"+", "-"},
+	{"*", "/", "%"},
+	{"^"},
+}
+
+func (p *fluxParser) binary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	if binaryLevels[level] == nil {
+		if p.is("not") || p.is("exists") {
+			t := p.next()
+			operand, err := p.binary(level)
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{at: at(t.pos), op: t.text, operand: operand}, nil
+		}
+		return p.binary(level + 1)
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokPunct && t.kind != tokIdent {
+			return left, nil
+		}
+		matched := false
+		for _, op := range binaryLevels[level] {
+			if t.text == op {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.next()
+		next := level + 1
+		if t.text == "^" {
+			// ^ is right associative
+			next = level
+		}
+		right, err := p.binary(next)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{at: at(t.pos), op: t.text, left: left, right: right}
+	}
+}
+
+func (p *fluxParser) unary() (node, error) {
+	if p.is("-") || p.is("+") {
+		t := p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{at: at(t.pos), op: t.text, operand: operand}, nil
+	}
+	return p.pipe()
+}
+
+func (p *fluxParser) pipe() (node, error) {
+	left, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("|>") {
+		t := p.next()
+		right, err := p.postfix()
+		if err != nil {
+			return nil, err
+		}
+		call, ok := right.(*callNode)
+		if !ok {
+			return nil, positionError(p.src, right.position(), "expected a function call after |>")
+		}
+		left = &pipeNode{at: at(t.pos), arg: left, call: call}
+	}
+	return left, nil
+}
+
+func (p *fluxParser) postfix() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, p.errorf(t, "expected property name")
+			}
+			n = &memberNode{at: at(n.position()), object: n, property: t.text}
+		case p.is("["):
+			p.next()
+			t := p.next()
+			if t.kind != tokString {
+				return nil, p.errorf(t, "expected string index")
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &memberNode{at: at(n.position()), object: n, property: t.value}
+		case p.is("("):
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			n = &callNode{at: at(n.position()), callee: n, args: args}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *fluxParser) args() ([]argNode, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []argNode
+	for !p.is(")") {
+		name := p.next()
+		if name.kind != tokIdent {
+			return nil, p.errorf(name, "expected argument name")
+		}
+		if _, err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argNode{name: name.text, value: value})
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
+
+// isFunction looks ahead for (a, b) => at the current parenthesis.
+func (p *fluxParser) isFunction() bool {
+	j := 1
+	for {
+		t := p.peekAt(j)
+		if t.kind == tokPunct && t.text == ")" {
+			next := p.peekAt(j + 1)
+			return next.kind == tokPunct && next.text == "=>"
+		}
+		if t.kind != tokIdent {
+			return false
+		}
+		sep := p.peekAt(j + 1)
+		if sep.kind != tokPunct || (sep.text != "," && sep.text != ")") {
+			return false
+		}
+		if sep.text == "," {
+			j += 2
+		} else {
+			j++
+		}
+	}
+}
+
+func (p *fluxParser) primary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokString:
+		p.next()
+		return &stringNode{at: at(t.pos), value: t.value}, nil
+	case tokInt:
+		p.next()
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid integer")
+		}
+		return &intNode{at: at(t.pos), value: v}, nil
+	case tokFloat:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid float")
+		}
+		return &floatNode{at: at(t.pos), value: v}, nil
+	case tokDuration:
+		p.next()
+		return &durationNode{at: at(t.pos), value: strings.ReplaceAll(t.text, "µs", "us")}, nil
+	case tokTime:
+		p.next()
+		layout := time.RFC3339Nano
+		if !strings.Contains(t.text, "T") {
+			layout = "2006-01-02"
+		}
+		v, err := time.Parse(layout, t.text)
+		if err != nil {
+			return nil, p.errorf(t, "invalid time")
+		}
+		return &timeNode{at: at(t.pos), value: v}, nil
+	case tokRegex:
+		p.next()
+		return &regexNode{at: at(t.pos), pattern: t.value}, nil
+	case tokIdent:
+		p.next()
+		return &identNode{at: at(t.pos), name: t.text}, nil
+	case tokPunct:
+		switch t.text {
+		case "(":
+			if p.isFunction() {
+				return p.function()
+			}
+			p.next()
+			n, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		case "[":
+			p.next()
+			a := &arrayNode{at: at(t.pos)}
+			for !p.is("]") {
+				e, err := p.expr()
+				if err != nil {
+					return nil, err
+				}
+				a.elements = append(a.elements, e)
+				if !p.is(",") {
+					break
+				}
+				p.next()
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return a, nil
+		case "{":
+			return p.record()
+		}
+	}
+	return nil, p.errorf(t, "unexpected token")
+}
+
+func (p *fluxParser) function() (node, error) {
+	start := p.next()
+	f := &functionNode{at: at(start.pos)}
+	for !p.is(")") {
+		f.params = append(f.params, p.next().text)
+		if p.is(",") {
+			p.next()
+		}
+	}
+	p.next()
+	if _, err := p.expect("=>"); err != nil {
+		return nil, err
+	}
+	if p.is("{") && !p.recordAhead() {
+		return nil, p.errorf(p.peek(), "function blocks are not supported")
+	}
+	body, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	f.body = body
+	end := len(p.src)
+	if p.i < len(p.tokens) {
+		end = p.peek().pos
+	}
+	f.src = strings.TrimSpace(p.src[start.pos:end])
+	f.src = strings.TrimRight(f.src, ",")
+	return f, nil
+}
+
+// recordAhead tells a record literal from a block after {.
+func (p *fluxParser) recordAhead() bool {
+	a, b := p.peekAt(1), p.peekAt(2)
+	if a.kind == tokPunct && a.text == "}" {
+		return true
+	}
+	if b.kind == tokIdent && b.text == "with" {
+		return true
+	}
+	return (a.kind == tokIdent || a.kind == tokString) && b.kind == tokPunct && b.text == ":"
+}
+
+func (p *fluxParser) record() (node, error) {
+	start := p.next()
+	r := &recordNode{at: at(start.pos)}
+	if p.peek().kind == tokIdent && p.peekAt(1).kind == tokIdent && p.peekAt(1).text == "with" {
+		base := p.next()
+		p.next()
+		r.with = &identNode{at: at(base.pos), name: base.text}
+	}
+	for !p.is("}") {
+		key := p.next()
+		if key.kind != tokIdent && key.kind != tokString {
+			return nil, p.errorf(key, "expected property name")
+		}
+		name := key.text
+		if key.kind == tokString {
+			name = key.value
+		}
+		if _, err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		r.properties = append(r.properties, propertyNode{key: name, value: value})
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
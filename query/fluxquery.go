@@ -4,17 +4,30 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/ThinkontrolSY/flux-builder/expression"
 	"github.com/ThinkontrolSY/flux-builder/filter"
+	"github.com/ThinkontrolSY/flux-builder/literal"
 	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
 )
 
 type FluxQuery struct {
 	Bucket     string
 	Timezone   *string
-	Start      *string
-	Stop       *string
+	Start      *TimeBound
+	Stop       *TimeBound
 	Filters    []*filter.FluxFilter
 	Transforms []pipe.TransformPipe
+
+	// Params are sent in the params object of the query request and are
+	// referenced in Flux as params.name, see expression.Param.
+	Params map[string]interface{}
+	// Parameterize binds the bucket, absolute range bounds and the filter
+	// values into Params instead of inlining them, so the script text only
+	// depends on the shape of the query.
+	Parameterize bool
+	// OptimizeFilters merges the filters into a single pushdown friendly
+	// filter, see filter.Optimize.
+	OptimizeFilters bool
 }
 
 func (q *FluxQuery) SetBucket(s string) *FluxQuery {
@@ -22,16 +35,24 @@ func (q *FluxQuery) SetBucket(s string) *FluxQuery {
 	return q
 }
 
-func (q *FluxQuery) SetStart(s *string) *FluxQuery {
+func (q *FluxQuery) SetStart(s *TimeBound) *FluxQuery {
 	q.Start = s
 	return q
 }
 
-func (q *FluxQuery) SetStop(s *string) *FluxQuery {
+func (q *FluxQuery) SetStop(s *TimeBound) *FluxQuery {
 	q.Stop = s
 	return q
 }
 
+func (q *FluxQuery) SetParam(name string, v interface{}) *FluxQuery {
+	if q.Params == nil {
+		q.Params = map[string]interface{}{}
+	}
+	q.Params[name] = v
+	return q
+}
+
 func (q *FluxQuery) AddFilter(f *filter.FluxFilter) *FluxQuery {
 	if f != nil {
 		q.Filters = append(q.Filters, f)
@@ -47,47 +68,147 @@ func (q *FluxQuery) AddTransform(f pipe.TransformPipe) *FluxQuery {
 }
 
 func (p *FluxQuery) QueryString() (string, error) {
-	pipes := []string{}
-	if p.Timezone != nil {
-		pipes = append(pipes, "import \"timezone\"")
-		pipes = append(pipes, fmt.Sprintf("option location = timezone.location(name: \"%s\")", *p.Timezone))
+	flux, _, err := p.Build()
+	return flux, err
+}
+
+// Build renders the script together with the params it references.
+func (p *FluxQuery) Build() (string, map[string]interface{}, error) {
+	params, b, err := bindParams(p.Parameterize, p.Params)
+	if err != nil {
+		return "", nil, err
+	}
+	body, err := p.pipeline(b)
+	if err != nil {
+		return "", nil, err
 	}
-	pipes = append(pipes, fmt.Sprintf("from(bucket: \"%s\")", p.Bucket))
+	pipes := header(p.Timezone, p.imports()...)
+	pipes = append(pipes, body...)
+	return strings.Join(pipes, "\n"), params, nil
+}
 
-	if p.Start == nil && p.Stop == nil {
-		return "", fmt.Errorf("start and stop are required")
+// bindParams merges explicit params and returns the binder values are
+// rendered with.
+func bindParams(parameterize bool, sets ...map[string]interface{}) (map[string]interface{}, *literal.Binder, error) {
+	params := map[string]interface{}{}
+	for _, set := range sets {
+		for name, v := range set {
+			if err := literal.Identifier(name); err != nil {
+				return nil, nil, fmt.Errorf("invalid param name: %w", err)
+			}
+			if _, ok := params[name]; ok {
+				return nil, nil, fmt.Errorf("duplicate param: %s", name)
+			}
+			params[name] = v
+		}
 	}
-	if p.Start != nil && p.Stop == nil {
-		pipes = append(pipes, fmt.Sprintf("|> range(start: %s)", *p.Start))
+	if !parameterize {
+		return params, nil, nil
 	}
-	if p.Start != nil && p.Stop != nil {
-		pipes = append(pipes, fmt.Sprintf("|> range(start: %s, stop: %s)", *p.Start, *p.Stop))
+	return params, literal.NewBinder(params), nil
+}
+
+// header renders the import block and the location option shared by every
+// script built in this package. Imports are deduplicated and sorted so that
+// the same query shape always produces the same script.
+func header(timezone *string, imports ...string) []string {
+	var lines []string
+	set := map[string]bool{}
+	for _, i := range imports {
+		set[i] = true
+	}
+	if timezone != nil {
+		set["timezone"] = true
 	}
-	if p.Start == nil && p.Stop != nil {
-		pipes = append(pipes, fmt.Sprintf("|> range(stop: %s)", *p.Stop))
+	for _, i := range expression.Sorted(set) {
+		lines = append(lines, fmt.Sprintf("import \"%s\"", i))
 	}
+	if timezone != nil {
+		lines = append(lines, fmt.Sprintf("option location = timezone.location(name: %s)", literal.String(*timezone)))
+	}
+	return lines
+}
 
-	for _, f := range p.Filters {
+// pipeline renders the from/range/filter/transform chain without any header,
+// so the same query can be embedded as a named stream in a larger script.
+func (p *FluxQuery) pipeline(b *literal.Binder) ([]string, error) {
+	pipes := []string{fmt.Sprintf("from(bucket: %s)", b.String(p.Bucket))}
+
+	rp, err := rangePipe(p.Start, p.Stop, p.Timezone, b)
+	if err != nil {
+		return nil, err
+	}
+	pipes = append(pipes, rp)
+
+	filters := p.Filters
+	if p.OptimizeFilters {
+		filters = []*filter.FluxFilter{filter.Optimize(p.Filters...)}
+	}
+	for _, f := range filters {
 		if f == nil {
 			continue
 		}
-		fp, err := f.Pipe()
+		fp, err := f.BindPipe(b)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		pipes = append(pipes, fp)
 	}
 
-	for _, t := range p.Transforms {
+	transforms, err := transformPipes(p.Transforms)
+	if err != nil {
+		return nil, err
+	}
+	return append(pipes, transforms...), nil
+}
+
+// imports collects the packages required by the range, the filters and the
+// transforms of the query.
+func (p *FluxQuery) imports() []string {
+	var imports []string
+	for _, b := range []*TimeBound{p.Start, p.Stop} {
+		if b != nil {
+			imports = append(imports, b.Imports()...)
+		}
+	}
+	for _, f := range p.Filters {
+		if f != nil {
+			imports = append(imports, f.Imports()...)
+		}
+	}
+	return append(imports, transformImports(p.Transforms)...)
+}
+
+func transformImports(transforms []pipe.TransformPipe) []string {
+	var imports []string
+	for _, t := range transforms {
+		if i, ok := t.(expression.Importer); ok {
+			imports = append(imports, i.Imports()...)
+		}
+	}
+	return imports
+}
+
+// Validate checks the params of every transform, see pipe.ValidatePipes.
+func (p *FluxQuery) Validate() error {
+	return pipe.ValidatePipes(p.Transforms)
+}
+
+func transformPipes(transforms []pipe.TransformPipe) ([]string, error) {
+	// report every invalid param at once rather than the first failing pipe
+	if err := pipe.ValidatePipes(transforms); err != nil {
+		return nil, err
+	}
+	var pipes []string
+	for _, t := range transforms {
 		if t == nil {
 			continue
 		}
 		tp, err := t.Pipe()
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		pipes = append(pipes, tp)
 	}
-
-	return strings.Join(pipes, "\n"), nil
+	return pipes, nil
 }
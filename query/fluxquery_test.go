@@ -0,0 +1,311 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+func TestFluxQuery_Imports(t *testing.T) {
+	q := measurementQuery("argiculture", "measure-sensor", "SoilTemperature")
+	q.AddTransform(&pipe.FieldsAsColsPipe{}).AddTransform(&pipe.FieldsAsColsPipe{})
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `import "influxdata/influxdb/schema"
+from(bucket: "argiculture")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "measure-sensor" and r._field == "SoilTemperature")
+|> schema.fieldsAsCols()
+|> schema.fieldsAsCols()`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+}
+
+func TestFluxQuery_ExpressionImports(t *testing.T) {
+	q := measurementQuery("argiculture", "measure-sensor", "SoilTemperature")
+	q.AddFilter(&filter.FluxFilter{
+		Expr: expression.Or(
+			expression.Call("strings.hasPrefix", expression.Named("v", expression.Col("host")), expression.Named("prefix", expression.String("gw-"))),
+			expression.Eq(expression.Col("host"), expression.String("local")),
+		),
+	})
+	q.AddTransform(&pipe.MapPipe{Fields: []pipe.MapField{
+		{Column: "_value", Value: expression.Call("math.abs", expression.Named("x", expression.Col("_value")))},
+	}})
+	q.AddTransform(&pipe.FieldsAsColsPipe{})
+	q.AddTransform(&pipe.FilterPipe{Fn: `(r) => types.isType(v: r.level, type: "string") and r.level != "x.y()"`})
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `import "influxdata/influxdb/schema"
+import "math"
+import "strings"
+import "types"
+from(bucket: "argiculture")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "measure-sensor" and r._field == "SoilTemperature")
+|> filter(fn: (r) => (strings.hasPrefix(v: r.host, prefix: "gw-") or r.host == "local"))
+|> map(fn: (r) => ({r with _value: math.abs(x: r._value)}))
+|> schema.fieldsAsCols()
+|> filter(fn: (r) => types.isType(v: r.level, type: "string") and r.level != "x.y()")`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+}
+
+func TestFluxQuery_Build(t *testing.T) {
+	q := measurementQuery("argiculture", "measure-sensor", `Soil"Temperature`)
+	q.Parameterize = true
+	q.SetParam("threshold", 20.5).
+		AddFilter(&filter.FluxFilter{Expr: expression.GT(expression.Col("_value"), expression.Param("threshold"))})
+
+	flux, params, err := q.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `from(bucket: params.p0)
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == params.p1 and r._field == params.p2)
+|> filter(fn: (r) => r._value > params.threshold)`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+	if len(params) != 4 || params["p0"] != "argiculture" || params["p2"] != `Soil"Temperature` || params["threshold"] != 20.5 {
+		t.Errorf("unexpected params: %v", params)
+	}
+
+	other := measurementQuery("site-b", "other", "field")
+	other.Parameterize = true
+	otherFlux, _, err := other.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherFlux != expected[:strings.LastIndex(expected, "\n")] {
+		t.Errorf("expected the same script for the same shape:\n%s", otherFlux)
+	}
+
+	q.SetParam("bad-name", 1)
+	if _, _, err := q.Build(); err == nil {
+		t.Error("expected error for an invalid param name")
+	}
+}
+
+func TestFluxQuery_Range(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		start, stop *TimeBound
+		expected    string
+	}{
+		{At(start), At(start.Add(time.Hour)), "|> range(start: 2024-01-01T00:00:00Z, stop: 2024-01-01T01:00:00Z)"},
+		{Relative("-1h"), Now(), "|> range(start: -1h, stop: now())"},
+		{Today(), nil, "|> range(start: today())"},
+		{Truncate("1mo").Shift("-1mo"), Truncate("1mo"), "|> range(start: date.sub(d: 1mo, from: date.truncate(t: now(), unit: 1mo)), stop: date.truncate(t: now(), unit: 1mo))"},
+	}
+	for _, c := range cases {
+		s, err := rangePipe(c.start, c.stop, nil, nil)
+		if err != nil {
+			t.Error(err)
+		} else if s != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, s)
+		}
+	}
+
+	invalid := [][2]*TimeBound{
+		{At(start), At(start)},
+		{Now(), Relative("-1d")},
+		{Truncate("1d"), Truncate("1d").Shift("-1d")},
+		{Relative("1 hour"), nil},
+	}
+	for _, c := range invalid {
+		if s, err := rangePipe(c[0], c[1], nil, nil); err == nil {
+			t.Errorf("expected error, got %s", s)
+		}
+	}
+
+	if _, err := ParseBound("2024-01-01"); err == nil {
+		t.Error("expected error for a date without time")
+	}
+	if b, err := ParseBound("-30m"); err != nil || b.Kind != RelativeBound {
+		t.Errorf("unexpected bound %+v, %v", b, err)
+	}
+
+	q := measurementQuery("argiculture", "measure-sensor", "SoilTemperature").
+		SetStart(Truncate("1d")).
+		SetStop(At(start.AddDate(100, 0, 0)))
+	q.Parameterize = true
+	flux, params, err := q.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(flux, "import \"date\"\nfrom(bucket: params.p0)\n|> range(start: date.truncate(t: now(), unit: 1d), stop: time(v: params.p1))") {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+	if params["p1"] != start.AddDate(100, 0, 0) {
+		t.Errorf("unexpected params: %v", params)
+	}
+}
+
+func TestFluxQuery_Validate(t *testing.T) {
+	every := pipe.Duration("5 minutes")
+	q := measurementQuery("argiculture", "measure-sensor", "SoilTemperature")
+	q.AddTransform(&pipe.WindowPipe{Every: &every}).
+		AddTransform(&pipe.MeanPipe{}).
+		AddTransform(&pipe.TopPipe{}).
+		AddTransform(&pipe.QuantilePipe{Q: 1.5}).
+		AddTransform(&pipe.FillPipe{})
+
+	_, err := q.QueryString()
+	var errs pipe.ParamErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected param errors, got %v", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, fmt.Sprintf("%d %s %s", e.Index, e.Fn, e.Param))
+	}
+	expected := []string{"0 window every", "2 top n", "3 quantile q", "4 fill value"}
+	if strings.Join(got, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("unexpected errors: %v", err)
+	}
+}
+
+func TestFluxQuery_Analyze(t *testing.T) {
+	schema := []*MeasurementSchema{
+		{Measurement: "cpu", Fields: []string{"usage"}, Tags: []string{"host", "region"}},
+		{Measurement: "mem", Fields: []string{"used"}, Tags: []string{"host"}},
+	}
+	q := measurementQuery("b", "cpu", "usage")
+	q.AddTransform(&pipe.DropPipe{Columns: []string{"_value", "region"}}).
+		AddTransform(&pipe.MeanPipe{}).
+		AddTransform(&pipe.GroupPipe{Columns: []string{"host"}}).
+		AddTransform(&pipe.DerivativePipe{})
+
+	s, err := q.Analyze(schema)
+	var errs pipe.ParamErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected param errors, got %v", err)
+	}
+	var got []string
+	for _, e := range errs {
+		got = append(got, e.Error())
+	}
+	expected := []string{
+		"transforms[1]: mean: column: column _value does not exist",
+		"transforms[3]: derivative: timeColumn: column _time does not exist",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected errors:\n%s", strings.Join(got, "\n"))
+	}
+	if strings.Join(s.Columns, ",") != "_field,_measurement,_start,_stop,_value,host" || strings.Join(s.GroupKey, ",") != "host" || s.Open {
+		t.Errorf("unexpected schema: %+v", s)
+	}
+
+	q = measurementQuery("b", "cpu", "usage")
+	q.AddTransform(&pipe.MapPipe{Replace: true, Fields: []pipe.MapField{
+		{Column: "_value", Value: expression.Col("_value")},
+		{Column: "_time", Value: expression.Col("_time")},
+	}}).AddTransform(&pipe.AggregatorPipe{Every: "5m", Fn: pipe.Mean})
+	if s, err := q.Analyze(schema); err != nil || strings.Join(s.Columns, ",") != "_field,_measurement,_start,_stop,_time,_value,host,region" {
+		t.Errorf("unexpected schema %+v, %v", s, err)
+	}
+
+	q = measurementQuery("b", "disk", "free")
+	q.AddTransform(&pipe.FieldsAsColsPipe{}).AddTransform(&pipe.KeepPipe{Columns: []string{"_time", "free"}})
+	if _, err := q.Analyze(schema); err == nil || err.Error() != "filters: measurement disk is not in the schema" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if s, err := q.Analyze(nil); err != nil || strings.Join(s.Columns, ",") != "_time,free" {
+		t.Errorf("unexpected schema %+v, %v", s, err)
+	}
+}
+
+func TestFluxQuery_Lint(t *testing.T) {
+	measurement, every, createEmpty := filter.Regex("^(cpu|mem)$"), pipe.Duration("1h"), true
+	q := &FluxQuery{
+		Bucket:  "b",
+		Start:   Relative("-30d"),
+		Filters: []*filter.FluxFilter{{MeasurementMatch: &measurement}},
+		Transforms: []pipe.TransformPipe{
+			&pipe.WindowPipe{Every: &every, CreateEmpty: &createEmpty},
+			&pipe.GroupPipe{},
+			&pipe.FilterPipe{Fn: `(r) => r._value > 0`},
+		},
+	}
+	var got []string
+	for _, f := range q.Lint(nil) {
+		got = append(got, f.String())
+	}
+	expected := []string{
+		"warning: the range spans 4w2d without aggregation, more than 7d (long-range)",
+		"warning: _measurement =~ ^(cpu|mem)$ only matches cpu, mem, use an equality (measurement-regex)",
+		"warning: transforms[2]: filter after a transform is not pushed down, move it before the transforms if possible (filter-after-transform)",
+		"warning: transforms[1]: group() without columns merges all series into one table (group-without-columns)",
+		"info: transforms[0]: empty windows are created but never filled (create-empty-without-fill)",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected findings:\n%s", strings.Join(got, "\n"))
+	}
+
+	findings := q.Lint(&LintConfig{
+		Disabled: []string{"long-range", "measurement-regex", "filter-after-transform", "create-empty-without-fill"},
+		Severity: map[string]Severity{"group-without-columns": SeverityError},
+	})
+	if len(findings) != 1 || findings[0].Rule != "group-without-columns" || !findings[0].Severity.AtLeast(SeverityWarning) {
+		t.Errorf("unexpected findings: %v", findings)
+	}
+
+	limited := measurementQuery("b", "cpu", "usage").SetStart(Relative("-30d")).AddTransform(&pipe.LimitPipe{N: 10})
+	if findings := limited.Lint(nil); len(findings) != 1 || findings[0].Rule != "long-range" {
+		t.Errorf("expected limit not to count as aggregation, got %v", findings)
+	}
+}
+
+func TestFluxQuery_OptimizeFilters(t *testing.T) {
+	m, f1, f2 := "cpu", "usage", "idle"
+	hosts := make([]*filter.FluxFilter, 0, 10)
+	for i := 0; i < 10; i++ {
+		h := fmt.Sprintf("h%d", i)
+		hosts = append(hosts, &filter.FluxFilter{Tags: []*filter.TagPredicate{{Key: "host", Op: filter.TagEq, Value: h}}})
+	}
+	q := &FluxQuery{
+		Bucket: "b",
+		Start:  Relative("-1h"),
+		Filters: []*filter.FluxFilter{
+			{Or: hosts},
+			{Not: &filter.FluxFilter{Or: []*filter.FluxFilter{
+				{Tags: []*filter.TagPredicate{{Key: "site", Op: filter.TagExists}}},
+				{Field: &f2},
+			}}},
+			{And: []*filter.FluxFilter{{Field: &f1}, {Measurement: &m}}},
+			{Measurement: &m},
+		},
+		OptimizeFilters: true,
+	}
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `from(bucket: "b")
+|> range(start: -1h)
+|> filter(fn: (r) => r._measurement == "cpu" and r._field == "usage" and r._field != "idle" and not exists r.site and contains(value: r.host, set: ["h0", "h1", "h2", "h3", "h4", "h5", "h6", "h7", "h8", "h9"]))`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+
+	q.Filters = q.Filters[1:2]
+	q.Filters[0].Not.Or = append(q.Filters[0].Not.Or, &filter.FluxFilter{Field: &f1})
+	flux, _ = q.QueryString()
+	if !strings.HasSuffix(flux, `filter(fn: (r) => r._field != "idle" and r._field != "usage" and not exists r.site)`) {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+}
@@ -0,0 +1,301 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/literal"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+type JoinMethod string
+
+const (
+	InnerJoin JoinMethod = "inner"
+	LeftJoin  JoinMethod = "left"
+	RightJoin JoinMethod = "right"
+	FullJoin  JoinMethod = "full"
+	// TimeJoin joins on _time and the shared group key, see join.time().
+	TimeJoin JoinMethod = "time"
+)
+
+// JoinSide names the lambda parameter of a join side: l for left, r for right.
+type JoinSide string
+
+const (
+	LeftSide  JoinSide = "l"
+	RightSide JoinSide = "r"
+)
+
+// JoinOn is one equality of the join predicate, rendered as l.Left == r.Right.
+// Right defaults to Left.
+type JoinOn struct {
+	Left  string
+	Right string
+}
+
+// JoinColumn is one property of the joined record, taken from Column of Side.
+type JoinColumn struct {
+	Name   string
+	Side   JoinSide
+	Column string
+}
+
+// JoinAs describes the output record of a join. With extends the record of
+// the given side, otherwise a new record containing only Columns is built.
+type JoinAs struct {
+	With    *JoinSide
+	Columns []JoinColumn
+}
+
+type FluxJoin struct {
+	// Name binds the join result so that later joins can reference it.
+	Name   string
+	Method JoinMethod
+	Left   string
+	Right  string
+	On     []JoinOn
+	As     JoinAs
+	// TimeMethod is the method parameter of join.time, defaults to inner.
+	TimeMethod *JoinMethod
+}
+
+type FluxStream struct {
+	Name  string
+	Query *FluxQuery
+}
+
+// JoinQuery declares several named FluxQuery streams and combines them with
+// the join package. The result of the last join is piped into Transforms.
+type JoinQuery struct {
+	// Timezone sets the location option of the whole script. The streams
+	// cannot set their own, since Flux has a single location option.
+	Timezone   *string
+	Streams    []*FluxStream
+	Joins      []*FluxJoin
+	Transforms []pipe.TransformPipe
+
+	// Params and Parameterize work as in FluxQuery. Params of the streams are
+	// merged into Params and Parameterize applies to all of them.
+	Params       map[string]interface{}
+	Parameterize bool
+}
+
+func (q *JoinQuery) AddStream(name string, s *FluxQuery) *JoinQuery {
+	if s != nil {
+		q.Streams = append(q.Streams, &FluxStream{Name: name, Query: s})
+	}
+	return q
+}
+
+func (q *JoinQuery) AddJoin(j *FluxJoin) *JoinQuery {
+	if j != nil {
+		q.Joins = append(q.Joins, j)
+	}
+	return q
+}
+
+func (q *JoinQuery) AddTransform(f pipe.TransformPipe) *JoinQuery {
+	if f != nil {
+		q.Transforms = append(q.Transforms, f)
+	}
+	return q
+}
+
+func (q *JoinQuery) QueryString() (string, error) {
+	flux, _, err := q.Build()
+	return flux, err
+}
+
+// Build renders the script together with the params it references.
+func (q *JoinQuery) Build() (string, map[string]interface{}, error) {
+	if len(q.Joins) == 0 {
+		return "", nil, fmt.Errorf("join query requires at least one join")
+	}
+	sets := []map[string]interface{}{q.Params}
+	for _, s := range q.Streams {
+		if s != nil && s.Query != nil {
+			sets = append(sets, s.Query.Params)
+		}
+	}
+	params, b, err := bindParams(q.Parameterize, sets...)
+	if err != nil {
+		return "", nil, err
+	}
+	imports := []string{"join"}
+	for _, s := range q.Streams {
+		if s != nil && s.Query != nil {
+			imports = append(imports, s.Query.imports()...)
+		}
+	}
+	imports = append(imports, transformImports(q.Transforms)...)
+	if q.Timezone != nil {
+		imports = append(imports, "timezone")
+	}
+	pipes := header(q.Timezone, imports...)
+
+	declared := map[string]bool{}
+	for _, s := range q.Streams {
+		if s == nil || s.Query == nil {
+			continue
+		}
+		if err := validIdentifier(s.Name, imports); err != nil {
+			return "", nil, err
+		}
+		if s.Query.Timezone != nil {
+			return "", nil, fmt.Errorf("stream %s: timezone can only be set on the join query", s.Name)
+		}
+		if declared[s.Name] {
+			return "", nil, fmt.Errorf("duplicate stream name: %s", s.Name)
+		}
+		body, err := s.Query.pipeline(b)
+		if err != nil {
+			return "", nil, fmt.Errorf("stream %s: %w", s.Name, err)
+		}
+		body[0] = fmt.Sprintf("%s = %s", s.Name, body[0])
+		pipes = append(pipes, body...)
+		declared[s.Name] = true
+	}
+
+	for i, j := range q.Joins {
+		last := i == len(q.Joins)-1
+		if j == nil {
+			return "", nil, fmt.Errorf("join %d is nil", i)
+		}
+		if !declared[j.Left] {
+			return "", nil, fmt.Errorf("join %d: undeclared left stream: %s", i, j.Left)
+		}
+		if !declared[j.Right] {
+			return "", nil, fmt.Errorf("join %d: undeclared right stream: %s", i, j.Right)
+		}
+		jp, err := j.call()
+		if err != nil {
+			return "", nil, fmt.Errorf("join %d: %w", i, err)
+		}
+		if last {
+			pipes = append(pipes, jp)
+			break
+		}
+		if err := validIdentifier(j.Name, imports); err != nil {
+			return "", nil, fmt.Errorf("join %d: %w", i, err)
+		}
+		if declared[j.Name] {
+			return "", nil, fmt.Errorf("duplicate stream name: %s", j.Name)
+		}
+		pipes = append(pipes, fmt.Sprintf("%s = %s", j.Name, jp))
+		declared[j.Name] = true
+	}
+
+	transforms, err := transformPipes(q.Transforms)
+	if err != nil {
+		return "", nil, err
+	}
+	pipes = append(pipes, transforms...)
+	return strings.Join(pipes, "\n"), params, nil
+}
+
+func (j *FluxJoin) call() (string, error) {
+	params := []string{
+		fmt.Sprintf("left: %s", j.Left),
+		fmt.Sprintf("right: %s", j.Right),
+	}
+	switch j.Method {
+	case InnerJoin, LeftJoin, RightJoin, FullJoin:
+		if len(j.On) == 0 {
+			return "", fmt.Errorf("join.%s requires at least one on column", j.Method)
+		}
+		if j.TimeMethod != nil {
+			return "", fmt.Errorf("join.%s does not accept a time method", j.Method)
+		}
+		on := make([]string, 0, len(j.On))
+		for _, o := range j.On {
+			if o.Left == "" {
+				return "", fmt.Errorf("join on requires a left column")
+			}
+			right := o.Right
+			if right == "" {
+				right = o.Left
+			}
+			on = append(on, fmt.Sprintf("%s == %s", literal.Member("l", o.Left), literal.Member("r", right)))
+		}
+		params = append(params, fmt.Sprintf("on: (l, r) => %s", strings.Join(on, " and ")))
+	case TimeJoin:
+		if len(j.On) > 0 {
+			return "", fmt.Errorf("join.time does not accept on columns")
+		}
+	default:
+		return "", fmt.Errorf("invalid join method: %s", j.Method)
+	}
+
+	as, err := j.As.fn()
+	if err != nil {
+		return "", err
+	}
+	params = append(params, fmt.Sprintf("as: %s", as))
+
+	if j.TimeMethod != nil {
+		switch *j.TimeMethod {
+		case InnerJoin, LeftJoin, RightJoin, FullJoin:
+			params = append(params, fmt.Sprintf("method: %s", literal.String(string(*j.TimeMethod))))
+		default:
+			return "", fmt.Errorf("invalid join.time method: %s", *j.TimeMethod)
+		}
+	}
+	return fmt.Sprintf("join.%s(%s)", j.Method, strings.Join(params, ", ")), nil
+}
+
+func (a JoinAs) fn() (string, error) {
+	if len(a.Columns) == 0 {
+		return "", fmt.Errorf("join as requires at least one column")
+	}
+	props := make([]string, 0, len(a.Columns))
+	for _, c := range a.Columns {
+		if err := c.Side.valid(); err != nil {
+			return "", err
+		}
+		if c.Name == "" || c.Column == "" {
+			return "", fmt.Errorf("join as column requires a name and a column")
+		}
+		name := c.Name
+		if !literal.IsIdentifier(name) {
+			name = literal.String(name)
+		}
+		props = append(props, fmt.Sprintf("%s: %s", name, literal.Member(string(c.Side), c.Column)))
+	}
+	if a.With != nil {
+		if err := a.With.valid(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(l, r) => ({%s with %s})", *a.With, strings.Join(props, ", ")), nil
+	}
+	return fmt.Sprintf("(l, r) => ({%s})", strings.Join(props, ", ")), nil
+}
+
+func (s JoinSide) valid() error {
+	if s != LeftSide && s != RightSide {
+		return fmt.Errorf("invalid join side: %s", s)
+	}
+	return nil
+}
+
+// reserved are the builtins the generated scripts call, which a stream of
+// the same name would shadow. The registered transforms are reserved too.
+var reserved = map[string]bool{
+	"from": true, "range": true, "filter": true, "union": true, "params": true, "v": true,
+	"now": true, "today": true, "time": true, "uint": true, "contains": true,
+}
+
+func validIdentifier(name string, imports []string) error {
+	if !literal.IsIdentifier(name) {
+		return fmt.Errorf("invalid stream name: %q", name)
+	}
+	if _, ok := pipe.Lookup(name); ok || reserved[name] {
+		return fmt.Errorf("stream name %s shadows a builtin", name)
+	}
+	for _, i := range imports {
+		if name == i[strings.LastIndex(i, "/")+1:] {
+			return fmt.Errorf("stream name %s shadows the imported package %s", name, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+func measurementQuery(bucket, measurement, field string) *FluxQuery {
+	return &FluxQuery{
+		Bucket: bucket,
+		Start:  Relative("-1d"),
+		Filters: []*filter.FluxFilter{
+			{Measurement: &measurement, Field: &field},
+		},
+	}
+}
+
+func TestJoinQuery_QueryString(t *testing.T) {
+	tz := "Asia/Shanghai"
+	with := LeftSide
+	q := &JoinQuery{Timezone: &tz}
+	q.AddStream("temp", measurementQuery("argiculture", "measure-sensor", "SoilTemperature")).
+		AddStream("moist", measurementQuery("argiculture", "moisture", "SoilVolumetricWaterContent")).
+		AddJoin(&FluxJoin{
+			Method: InnerJoin,
+			Left:   "temp",
+			Right:  "moist",
+			On:     []JoinOn{{Left: "_time"}, {Left: "sensor", Right: "device"}},
+			As: JoinAs{
+				With:    &with,
+				Columns: []JoinColumn{{Name: "moisture", Side: RightSide, Column: "_value"}},
+			},
+		}).
+		AddTransform(&pipe.YieldPipe{})
+
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `import "join"
+import "timezone"
+option location = timezone.location(name: "Asia/Shanghai")
+temp = from(bucket: "argiculture")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "measure-sensor" and r._field == "SoilTemperature")
+moist = from(bucket: "argiculture")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "moisture" and r._field == "SoilVolumetricWaterContent")
+join.inner(left: temp, right: moist, on: (l, r) => l._time == r._time and l.sensor == r.device, as: (l, r) => ({l with moisture: r._value}))
+|> yield()`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+}
+
+func TestJoinQuery_ChainedTimeJoin(t *testing.T) {
+	full := FullJoin
+	q := &JoinQuery{
+		Streams: []*FluxStream{
+			{Name: "a", Query: measurementQuery("b", "m", "a")},
+			{Name: "b", Query: measurementQuery("b", "m", "b")},
+			{Name: "c", Query: measurementQuery("b", "m", "c")},
+		},
+		Joins: []*FluxJoin{
+			{
+				Name: "ab", Method: TimeJoin, Left: "a", Right: "b",
+				As: JoinAs{Columns: []JoinColumn{{Name: "a", Side: LeftSide, Column: "_value"}, {Name: "b", Side: RightSide, Column: "_value"}}},
+			},
+			{
+				Method: TimeJoin, Left: "ab", Right: "c", TimeMethod: &full,
+				As: JoinAs{Columns: []JoinColumn{{Name: "a", Side: LeftSide, Column: "a"}, {Name: "c", Side: RightSide, Column: "_value"}}},
+			},
+		},
+	}
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `import "join"
+a = from(bucket: "b")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "m" and r._field == "a")
+b = from(bucket: "b")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "m" and r._field == "b")
+c = from(bucket: "b")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "m" and r._field == "c")
+ab = join.time(left: a, right: b, as: (l, r) => ({a: l._value, b: r._value}))
+join.time(left: ab, right: c, as: (l, r) => ({a: l.a, c: r._value}), method: "full")`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+
+	q.Joins[1].Left = "missing"
+	if _, err := q.QueryString(); err == nil {
+		t.Error("expected error for undeclared stream")
+	}
+	q.Joins[1].Left = "ab"
+	q.Joins[0].On = []JoinOn{{Left: "_time"}}
+	if _, err := q.QueryString(); err == nil {
+		t.Error("expected error for on columns in join.time")
+	}
+}
+
+func TestJoinQuery_StreamNames(t *testing.T) {
+	tz := "UTC"
+	for _, name := range []string{"join", "from", "union", "range", "params", "mean", "timezone", "date", "1a"} {
+		q := &JoinQuery{Timezone: &tz}
+		q.AddStream(name, measurementQuery("b", "m", "a").SetStart(Truncate("1d"))).
+			AddStream("b", measurementQuery("b", "m", "b")).
+			AddJoin(&FluxJoin{Method: TimeJoin, Left: name, Right: "b", As: JoinAs{Columns: []JoinColumn{{Name: "a", Side: LeftSide, Column: "_value"}}}})
+		if _, err := q.QueryString(); err == nil {
+			t.Errorf("expected an error for the stream name %s", name)
+		}
+	}
+
+	a := measurementQuery("b", "m", "a")
+	a.Timezone = &tz
+	q := &JoinQuery{}
+	q.AddStream("a", a).
+		AddStream("b", measurementQuery("b", "m", "b")).
+		AddJoin(&FluxJoin{Method: TimeJoin, Left: "a", Right: "b", As: JoinAs{Columns: []JoinColumn{{Name: "a", Side: LeftSide, Column: "_value"}}}})
+	if _, err := q.QueryString(); err == nil {
+		t.Error("expected an error for a stream timezone")
+	}
+}
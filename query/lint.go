@@ -0,0 +1,321 @@
+package query
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityError:
+		return 3
+	}
+	return 0
+}
+
+// AtLeast reports whether s is as severe as o, e.g. to fail a CI check on
+// warnings and errors only.
+func (s Severity) AtLeast(o Severity) bool {
+	return s.rank() >= o.rank()
+}
+
+// LintFinding is a problem reported by a lint rule. Index is the position of
+// the transform the finding is about, or -1 for the range and the filters.
+type LintFinding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Index    int      `json:"index"`
+	Message  string   `json:"message"`
+}
+
+func (f *LintFinding) String() string {
+	if f.Index >= 0 {
+		return fmt.Sprintf("%s: transforms[%d]: %s (%s)", f.Severity, f.Index, f.Message, f.Rule)
+	}
+	return fmt.Sprintf("%s: %s (%s)", f.Severity, f.Message, f.Rule)
+}
+
+// LintRule checks a query for one anti-pattern. Check returns findings
+// without a severity, the severity of the rule or of the config is applied
+// by Lint.
+type LintRule struct {
+	Name        string
+	Description string
+	Severity    Severity
+	Check       func(q *FluxQuery, c *LintConfig) []*LintFinding
+}
+
+// LintConfig selects the rules of Lint. The zero value runs every rule with
+// its default severity.
+type LintConfig struct {
+	// Disabled lists the names of the rules to skip.
+	Disabled []string `json:"disabled,omitempty"`
+	// Severity overrides the default severity of rules by name.
+	Severity map[string]Severity `json:"severity,omitempty"`
+	// MaxRange is the longest range read without aggregation. Defaults to 7d.
+	MaxRange pipe.Duration `json:"maxRange,omitempty"`
+	// Now resolves relative bounds. Defaults to time.Now().
+	Now time.Time `json:"-"`
+}
+
+// LintRules returns the built-in rules.
+func LintRules() []LintRule {
+	return []LintRule{
+		{Name: "unbounded-range", Severity: SeverityError,
+			Description: "The range has no start, or its length cannot be known, and the data is not aggregated.", Check: lintUnboundedRange},
+		{Name: "long-range", Severity: SeverityWarning,
+			Description: "The range is longer than MaxRange and the data is not aggregated.", Check: lintLongRange},
+		{Name: "measurement-regex", Severity: SeverityWarning,
+			Description: "A regular expression on _measurement only matches fixed names, an equality is cheaper.", Check: lintMeasurementRegex},
+		{Name: "filter-after-transform", Severity: SeverityWarning,
+			Description: "A filter placed after a transform is not pushed down to the storage.", Check: lintFilterAfterTransform},
+		{Name: "group-without-columns", Severity: SeverityWarning,
+			Description: "group() without columns merges all series into a single table.", Check: lintGroupWithoutColumns},
+		{Name: "create-empty-without-fill", Severity: SeverityInfo,
+			Description: "Windows created empty hold null values unless a fill follows.", Check: lintCreateEmptyWithoutFill},
+	}
+}
+
+// Lint runs the enabled rules over the query.
+func (q *FluxQuery) Lint(c *LintConfig) []*LintFinding {
+	if c == nil {
+		c = &LintConfig{}
+	}
+	disabled := map[string]bool{}
+	for _, name := range c.Disabled {
+		disabled[name] = true
+	}
+	var findings []*LintFinding
+	for _, r := range LintRules() {
+		if disabled[r.Name] {
+			continue
+		}
+		severity := r.Severity
+		if s, ok := c.Severity[r.Name]; ok {
+			severity = s
+		}
+		for _, f := range r.Check(q, c) {
+			f.Rule = r.Name
+			f.Severity = severity
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// aggregated reports whether a transform reduces each window or group to a
+// single row, so that the length of the range does not matter much. Selectors
+// such as limit, first or top do not count, they still read the whole range
+// and return its rows unchanged.
+func aggregated(transforms []pipe.TransformPipe) bool {
+	for _, t := range transforms {
+		switch t.(type) {
+		case *pipe.AggregatorPipe, *pipe.CountPipe, *pipe.MeanPipe, *pipe.SumPipe, *pipe.MedianPipe, *pipe.ModePipe,
+			*pipe.QuantilePipe, *pipe.SkewPipe, *pipe.SpreadPipe, *pipe.StddevPipe, *pipe.IntegralPipe,
+			*pipe.TimeWeightedAvgPipe, *pipe.MinPipe, *pipe.MaxPipe:
+			return true
+		}
+	}
+	return false
+}
+
+// span resolves the length of the range. It reports false when a bound is
+// unknown before the query runs, e.g. a dashboard variable.
+func (q *FluxQuery) span(now time.Time) (time.Duration, bool) {
+	if q.Start == nil {
+		return 0, false
+	}
+	loc := time.UTC
+	if q.Timezone != nil {
+		if l, err := time.LoadLocation(*q.Timezone); err == nil {
+			loc = l
+		}
+	}
+	stop := Now()
+	if q.Stop != nil {
+		stop = q.Stop
+	}
+	start, ok := q.Start.resolve(now, loc)
+	if !ok {
+		return 0, false
+	}
+	end, ok := stop.resolve(now, loc)
+	if !ok {
+		return 0, false
+	}
+	return end.Sub(start), true
+}
+
+func (c *LintConfig) now() time.Time {
+	if c.Now.IsZero() {
+		return time.Now()
+	}
+	return c.Now
+}
+
+func lintUnboundedRange(q *FluxQuery, c *LintConfig) []*LintFinding {
+	if aggregated(q.Transforms) {
+		return nil
+	}
+	if q.Start == nil {
+		return []*LintFinding{{Index: -1, Message: "the range has no start"}}
+	}
+	if q.Start.Kind == VariableBound || (q.Stop != nil && q.Stop.Kind == VariableBound) {
+		// dashboards bound the variables themselves
+		return nil
+	}
+	if _, ok := q.span(c.now()); !ok {
+		return []*LintFinding{{Index: -1, Message: "the length of the range cannot be determined"}}
+	}
+	return nil
+}
+
+func lintLongRange(q *FluxQuery, c *LintConfig) []*LintFinding {
+	if aggregated(q.Transforms) {
+		return nil
+	}
+	max := c.MaxRange
+	if max == "" {
+		max = "7d"
+	}
+	limit, err := max.TimeDuration()
+	if err != nil {
+		return []*LintFinding{{Index: -1, Message: fmt.Sprintf("invalid MaxRange: %s", err)}}
+	}
+	if span, ok := q.span(c.now()); ok && span > limit {
+		return []*LintFinding{{Index: -1, Message: fmt.Sprintf("the range spans %s without aggregation, more than %s", pipe.FromDuration(span), max)}}
+	}
+	return nil
+}
+
+func lintMeasurementRegex(q *FluxQuery, c *LintConfig) []*LintFinding {
+	var findings []*LintFinding
+	var walk func(f *filter.FluxFilter)
+	walk = func(f *filter.FluxFilter) {
+		if f == nil {
+			return
+		}
+		if f.MeasurementMatch != nil {
+			if names, ok := literalAlternatives(f.MeasurementMatch.Pattern()); ok {
+				findings = append(findings, &LintFinding{Index: -1, Message: fmt.Sprintf(
+					"_measurement =~ %s only matches %s, use an equality", *f.MeasurementMatch, strings.Join(names, ", "))})
+			}
+		}
+		walk(f.Not)
+		for _, o := range f.Or {
+			walk(o)
+		}
+		for _, a := range f.And {
+			walk(a)
+		}
+	}
+	for _, f := range q.Filters {
+		walk(f)
+	}
+	return findings
+}
+
+// literalAlternatives returns the strings an anchored regular expression such
+// as ^cpu$ or ^(cpu|mem)$ matches exactly.
+func literalAlternatives(pattern string) ([]string, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	if re.Op != syntax.OpConcat || len(re.Sub) != 3 ||
+		re.Sub[0].Op != syntax.OpBeginText || re.Sub[2].Op != syntax.OpEndText {
+		return nil, false
+	}
+	body := re.Sub[1]
+	for body.Op == syntax.OpCapture {
+		body = body.Sub[0]
+	}
+	switch body.Op {
+	case syntax.OpLiteral:
+		if body.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(body.Rune)}, true
+	case syntax.OpAlternate:
+		var names []string
+		for _, sub := range body.Sub {
+			if sub.Op != syntax.OpLiteral || sub.Flags&syntax.FoldCase != 0 {
+				return nil, false
+			}
+			names = append(names, string(sub.Rune))
+		}
+		return names, true
+	}
+	return nil, false
+}
+
+func lintFilterAfterTransform(q *FluxQuery, c *LintConfig) []*LintFinding {
+	var findings []*LintFinding
+	transformed := false
+	for i, t := range q.Transforms {
+		if _, ok := t.(*pipe.FilterPipe); !ok {
+			transformed = true
+			continue
+		}
+		if transformed {
+			findings = append(findings, &LintFinding{Index: i, Message: "filter after a transform is not pushed down, move it before the transforms if possible"})
+		}
+	}
+	return findings
+}
+
+func lintGroupWithoutColumns(q *FluxQuery, c *LintConfig) []*LintFinding {
+	var findings []*LintFinding
+	for i, t := range q.Transforms {
+		g, ok := t.(*pipe.GroupPipe)
+		if ok && len(g.Columns) == 0 && (g.Mode == nil || *g.Mode == "by") {
+			findings = append(findings, &LintFinding{Index: i, Message: "group() without columns merges all series into one table"})
+		}
+	}
+	return findings
+}
+
+func lintCreateEmptyWithoutFill(q *FluxQuery, c *LintConfig) []*LintFinding {
+	var findings []*LintFinding
+	for i, t := range q.Transforms {
+		var createEmpty bool
+		switch a := t.(type) {
+		case *pipe.AggregatorPipe:
+			// aggregateWindow creates empty windows by default
+			createEmpty = a.CreateEmpty == nil || *a.CreateEmpty
+		case *pipe.WindowPipe:
+			createEmpty = a.CreateEmpty != nil && *a.CreateEmpty
+		}
+		if !createEmpty {
+			continue
+		}
+		filled := false
+		for _, next := range q.Transforms[i+1:] {
+			if _, ok := next.(*pipe.FillPipe); ok {
+				filled = true
+				break
+			}
+		}
+		if !filled {
+			findings = append(findings, &LintFinding{Index: i, Message: "empty windows are created but never filled"})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,798 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+// UnsupportedError lists the parts of a script Parse could not map onto
+// FluxQuery. Each construct is prefixed with its line:column.
+type UnsupportedError struct {
+	Constructs []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("unsupported flux constructs: %s", strings.Join(e.Constructs, "; "))
+}
+
+// Parse reads a script of the shape FluxQuery renders, from() |> range()
+// followed by filters and transforms, back into a FluxQuery.
+//
+// Constructs the builder cannot represent are skipped and reported in an
+// *UnsupportedError, which is returned together with the partial query so
+// callers can decide whether the migration is good enough.
+func Parse(script string) (*FluxQuery, error) {
+	statements, err := parseFlux(script)
+	if err != nil {
+		return nil, err
+	}
+	c := &converter{src: script}
+	q := &FluxQuery{}
+	var chain node
+	for _, s := range statements {
+		switch s.kind {
+		case "import":
+			// imports are derived from the query when it is rendered again
+		case "option":
+			if tz, ok := timezoneOption(s); ok {
+				q.Timezone = &tz
+			} else {
+				c.unsupported(s.pos, "option %s", s.name)
+			}
+		case "assign":
+			c.unsupported(s.pos, "assignment to %s", s.name)
+		case "expression":
+			if chain != nil {
+				c.unsupported(s.pos, "additional expression statement")
+				continue
+			}
+			chain = s.value
+		}
+	}
+	if chain == nil {
+		return nil, fmt.Errorf("script has no query expression")
+	}
+
+	root, calls := flattenPipes(chain)
+	from, ok := root.(*callNode)
+	if !ok || calleeName(from.callee) != "from" {
+		return nil, positionError(script, root.position(), "query must start with from()")
+	}
+	for _, a := range from.args {
+		if s, ok := a.value.(*stringNode); ok && a.name == "bucket" {
+			q.Bucket = s.value
+		} else {
+			c.unsupported(a.value.position(), "from argument %s", a.name)
+		}
+	}
+	if q.Bucket == "" {
+		return nil, positionError(script, from.position(), "from() requires a bucket")
+	}
+
+	// filters become FluxFilter trees until the first transform
+	transformed := false
+	for i, call := range calls {
+		name := calleeName(call.callee)
+		switch {
+		case name == "range" && i == 0:
+			c.rangeCall(q, call)
+		case name == "filter" && !transformed:
+			c.filterCall(q, call)
+		default:
+			transformed = true
+			if t := c.transform(call); t != nil {
+				q.Transforms = append(q.Transforms, t)
+			}
+		}
+	}
+	if len(calls) == 0 || calleeName(calls[0].callee) != "range" {
+		c.unsupported(from.position(), "query without range()")
+	}
+
+	if len(c.constructs) > 0 {
+		return q, &UnsupportedError{Constructs: c.constructs}
+	}
+	return q, nil
+}
+
+type converter struct {
+	src        string
+	constructs []string
+}
+
+func (c *converter) unsupported(pos int, format string, args ...interface{}) {
+	c.constructs = append(c.constructs, fmt.Sprintf("%s: %s", position(c.src, pos), fmt.Sprintf(format, args...)))
+}
+
+func timezoneOption(s statement) (string, bool) {
+	call, ok := s.value.(*callNode)
+	if s.name != "location" || !ok || calleeName(call.callee) != "timezone.location" || len(call.args) != 1 {
+		return "", false
+	}
+	name, ok := call.args[0].value.(*stringNode)
+	if !ok || call.args[0].name != "name" {
+		return "", false
+	}
+	return name.value, true
+}
+
+func flattenPipes(n node) (node, []*callNode) {
+	p, ok := n.(*pipeNode)
+	if !ok {
+		return n, nil
+	}
+	root, calls := flattenPipes(p.arg)
+	return root, append(calls, p.call)
+}
+
+// calleeName returns the dotted name of a called function, e.g.
+// schema.fieldsAsCols, or "" for anything else.
+func calleeName(n node) string {
+	switch n := n.(type) {
+	case *identNode:
+		return n.name
+	case *memberNode:
+		if obj := calleeName(n.object); obj != "" {
+			return obj + "." + n.property
+		}
+	}
+	return ""
+}
+
+func (c *converter) rangeCall(q *FluxQuery, call *callNode) {
+	for _, a := range call.args {
+		b, ok := bound(a.value)
+		if !ok {
+			c.unsupported(a.value.position(), "range %s value", a.name)
+			continue
+		}
+		switch a.name {
+		case "start":
+			q.Start = b
+		case "stop":
+			q.Stop = b
+		default:
+			c.unsupported(a.value.position(), "range argument %s", a.name)
+		}
+	}
+}
+
+func bound(n node) (*TimeBound, bool) {
+	switch n := n.(type) {
+	case *durationNode:
+		return Relative(pipe.Duration(n.value)), true
+	case *unaryNode:
+		if d, ok := n.operand.(*durationNode); ok && n.op == "-" {
+			return Relative(pipe.Duration("-" + d.value)), true
+		}
+	case *timeNode:
+		return At(n.value), true
+	case *identNode, *memberNode:
+		if name := calleeName(n); name != "" {
+			return Variable(name), true
+		}
+	case *callNode:
+		args := map[string]node{}
+		for _, a := range n.args {
+			args[a.name] = a.value
+		}
+		switch name := calleeName(n.callee); {
+		case name == "now" && len(args) == 0:
+			return Now(), true
+		case name == "today" && len(args) == 0:
+			return Today(), true
+		case name == "time" && len(args) == 1:
+			if s, ok := args["v"].(*stringNode); ok {
+				if t, err := time.Parse(time.RFC3339Nano, s.value); err == nil {
+					return At(t), true
+				}
+			}
+		case name == "date.truncate" && len(args) == 2:
+			t, ok := args["t"].(*callNode)
+			unit, unitOk := args["unit"].(*durationNode)
+			if ok && unitOk && calleeName(t.callee) == "now" && len(t.args) == 0 {
+				return Truncate(pipe.Duration(unit.value)), true
+			}
+		case (name == "date.add" || name == "date.sub") && len(args) == 2:
+			d, ok := args["d"].(*durationNode)
+			base := args["to"]
+			if name == "date.sub" {
+				base = args["from"]
+			}
+			if !ok || base == nil {
+				return nil, false
+			}
+			offset := d.value
+			if name == "date.sub" {
+				offset = "-" + offset
+			}
+			if b, ok := bound(base); ok && b.Offset == nil && (b.Kind == NowBound || b.Kind == TodayBound || b.Kind == TruncateBound) {
+				return b.Shift(pipe.Duration(offset)), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (c *converter) filterCall(q *FluxQuery, call *callNode) {
+	fn, ok := filterFn(call)
+	if !ok {
+		c.unsupported(call.position(), "filter arguments")
+		return
+	}
+	f, err := toFilter(fn.body, fn.params[0])
+	if err != nil {
+		// filters commute, so keeping it as a raw pipe preserves the result
+		q.Transforms = append(q.Transforms, &pipe.FilterPipe{Fn: fn.src})
+		return
+	}
+	q.Filters = append(q.Filters, f)
+}
+
+func filterFn(call *callNode) (*functionNode, bool) {
+	if len(call.args) != 1 || call.args[0].name != "fn" {
+		return nil, false
+	}
+	fn, ok := call.args[0].value.(*functionNode)
+	return fn, ok && len(fn.params) == 1
+}
+
+// transform maps any other call onto its TransformPipe through
+// TransformInput, the same path the UI uses.
+func (c *converter) transform(call *callNode) pipe.TransformPipe {
+	name := calleeName(call.callee)
+	switch name {
+	case "filter":
+		fn, ok := filterFn(call)
+		if !ok {
+			c.unsupported(call.position(), "filter arguments")
+			return nil
+		}
+		return &pipe.FilterPipe{Fn: fn.src}
+	case "map":
+		return c.mapCall(call)
+	case "schema.fieldsAsCols":
+		name = "fieldsAsCols"
+	}
+	if strings.Contains(name, ".") || name == "range" || name == "" {
+		c.unsupported(call.position(), "call to %s", calleeName(call.callee))
+		return nil
+	}
+
+	input := pipe.TransformInput{Fn: name}
+	for _, a := range call.args {
+		v, ok := c.value(a.value)
+		if id, isIdent := a.value.(*identNode); isIdent && !ok && a.name == "fn" {
+			// function references such as fn: mean
+			v, ok = id.name, true
+		}
+		if !ok {
+			c.unsupported(a.value.position(), "%s argument %s", name, a.name)
+			continue
+		}
+		if input.Params == nil {
+			input.Params = map[string]interface{}{}
+		}
+		input.Params[a.name] = v
+	}
+	t, err := input.Transform()
+	if err != nil {
+		c.unsupported(call.position(), "%s: %s", name, err)
+		return nil
+	}
+	for _, a := range call.args {
+		if !hasField(t, a.name) {
+			c.unsupported(a.value.position(), "%s argument %s", name, a.name)
+		}
+	}
+	return t
+}
+
+// hasField reports whether the pipe has a field the argument decodes into.
+// mapstructure ignores unknown keys, so without this check arguments would
+// be dropped silently.
+func hasField(t pipe.TransformPipe, arg string) bool {
+	v := reflect.Indirect(reflect.ValueOf(t))
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < v.NumField(); i++ {
+		if strings.EqualFold(v.Type().Field(i).Name, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// value converts an argument into the Go value TransformInput expects.
+func (c *converter) value(n node) (interface{}, bool) {
+	switch n := n.(type) {
+	case *stringNode:
+		return n.value, true
+	case *intNode:
+		return n.value, true
+	case *floatNode:
+		return n.value, true
+	case *durationNode:
+		return n.value, true
+	case *unaryNode:
+		if n.op != "-" {
+			return nil, false
+		}
+		switch o := n.operand.(type) {
+		case *durationNode:
+			return "-" + o.value, true
+		case *intNode:
+			return -o.value, true
+		case *floatNode:
+			return -o.value, true
+		}
+	case *identNode:
+		switch n.name {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	case *arrayNode:
+		values := make([]interface{}, 0, len(n.elements))
+		for _, e := range n.elements {
+			v, ok := c.value(e)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, v)
+		}
+		return values, true
+	case *functionNode:
+		return n.src, true
+	}
+	return nil, false
+}
+
+func (c *converter) mapCall(call *callNode) pipe.TransformPipe {
+	fn, ok := filterFn(call)
+	if !ok {
+		c.unsupported(call.position(), "map arguments")
+		return nil
+	}
+	record, ok := fn.body.(*recordNode)
+	if !ok {
+		c.unsupported(fn.position(), "map function that does not return a record")
+		return nil
+	}
+	m := &pipe.MapPipe{Replace: true}
+	if record.with != nil {
+		if base, ok := record.with.(*identNode); !ok || base.name != fn.params[0] {
+			c.unsupported(record.position(), "map record extending another record")
+			return nil
+		}
+		m.Replace = false
+	}
+	for _, p := range record.properties {
+		e, err := toExpr(p.value, fn.params[0])
+		if err != nil {
+			c.unsupported(p.value.position(), "map field %s: %s", p.key, err)
+			return nil
+		}
+		m.Fields = append(m.Fields, pipe.MapField{Column: p.key, Value: e})
+	}
+	return m
+}
+
+// toFilter maps a filter predicate onto a FluxFilter tree. Conjuncts fill the
+// fields of one node where they can and become And children otherwise, or
+// Expr when no field models them.
+func toFilter(n node, param string) (*filter.FluxFilter, error) {
+	f := &filter.FluxFilter{}
+	for _, conj := range conjuncts(n) {
+		if setField(f, conj, param) {
+			continue
+		}
+		switch conj := conj.(type) {
+		case *binaryNode:
+			if conj.op == "or" && len(f.Or) == 0 {
+				for _, d := range disjuncts(conj) {
+					child, err := toFilter(d, param)
+					if err != nil {
+						return nil, err
+					}
+					f.Or = append(f.Or, child)
+				}
+				continue
+			}
+		case *unaryNode:
+			if conj.op == "not" && f.Not == nil {
+				child, err := toFilter(conj.operand, param)
+				if err != nil {
+					return nil, err
+				}
+				f.Not = child
+				continue
+			}
+		}
+		if f.Expr == nil && !isLogical(conj) {
+			e, err := toExpr(conj, param)
+			if err != nil {
+				return nil, err
+			}
+			f.Expr = e
+			continue
+		}
+		child, err := toFilter(conj, param)
+		if err != nil {
+			return nil, err
+		}
+		f.And = append(f.And, child)
+	}
+	return f, nil
+}
+
+// isLogical reports whether n is an or or a not, which become child nodes
+// rather than Expr.
+func isLogical(n node) bool {
+	switch n := n.(type) {
+	case *binaryNode:
+		return n.op == "or"
+	case *unaryNode:
+		return n.op == "not"
+	}
+	return false
+}
+
+func conjuncts(n node) []node {
+	if b, ok := n.(*binaryNode); ok && b.op == "and" {
+		return append(conjuncts(b.left), conjuncts(b.right)...)
+	}
+	return []node{n}
+}
+
+func disjuncts(n node) []node {
+	if b, ok := n.(*binaryNode); ok && b.op == "or" {
+		return append(disjuncts(b.left), disjuncts(b.right)...)
+	}
+	return []node{n}
+}
+
+// column returns the column n reads from the record param.
+func column(n node, param string) (string, bool) {
+	m, ok := n.(*memberNode)
+	if !ok {
+		return "", false
+	}
+	obj, ok := m.object.(*identNode)
+	return m.property, ok && obj.name == param
+}
+
+// setField stores a single comparison in the matching field of f, if that
+// field is still free. Tag predicates and comparisons are appended.
+func setField(f *filter.FluxFilter, n node, param string) bool {
+	switch n := n.(type) {
+	case *callNode:
+		return setIn(f, n, param, true)
+	case *unaryNode:
+		if call, ok := n.operand.(*callNode); ok && n.op == "not" {
+			return setIn(f, call, param, false)
+		}
+		op := filter.TagExists
+		if n.op == "not" {
+			inner, ok := n.operand.(*unaryNode)
+			if !ok {
+				return false
+			}
+			n, op = inner, filter.TagNotExists
+		}
+		col, ok := column(n.operand, param)
+		if n.op != "exists" || !ok {
+			return false
+		}
+		f.Tags = append(f.Tags, &filter.TagPredicate{Key: col, Op: op})
+		return true
+	case *binaryNode:
+		col, ok := column(n.left, param)
+		if !ok {
+			return false
+		}
+		_, str := n.right.(*stringNode)
+		_, re := n.right.(*regexNode)
+		if !re && (col == "_value" || !str || (n.op != "==" && n.op != "!=")) {
+			c, ok := comparison(col, n)
+			if ok {
+				f.Compare = append(f.Compare, c)
+			}
+			return ok
+		}
+		switch r := n.right.(type) {
+		case *stringNode:
+			if n.op != "==" && n.op != "!=" {
+				return false
+			}
+			slot := map[string]map[string]**string{
+				"_measurement": {"==": &f.Measurement, "!=": &f.MeasurementNEQ},
+				"_field":       {"==": &f.Field, "!=": &f.FieldNEQ},
+			}[col]
+			if slot == nil {
+				f.Tags = append(f.Tags, &filter.TagPredicate{Key: col, Op: filter.TagOp(n.op), Value: r.value})
+				return true
+			}
+			if *slot[n.op] != nil {
+				return false
+			}
+			value := r.value
+			*slot[n.op] = &value
+		case *regexNode:
+			if n.op != "=~" && n.op != "!~" {
+				return false
+			}
+			slot := map[string]map[string]**filter.Regex{
+				"_measurement": {"=~": &f.MeasurementMatch, "!~": &f.MeasurementNMatch},
+				"_field":       {"=~": &f.FieldMatch, "!~": &f.FieldNMatch},
+			}[col]
+			if slot == nil {
+				f.Tags = append(f.Tags, &filter.TagPredicate{Key: col, Op: filter.TagOp(n.op), Regex: filter.Regex(r.pattern)})
+				return true
+			}
+			if *slot[n.op] != nil {
+				return false
+			}
+			re := filter.Regex(r.pattern)
+			*slot[n.op] = &re
+		default:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// setIn stores contains(value: r.column, set: [...]) of string literals in
+// the In or NotIn field of the column.
+func setIn(f *filter.FluxFilter, n *callNode, param string, in bool) bool {
+	if callee, ok := n.callee.(*identNode); !ok || callee.name != "contains" || len(n.args) != 2 {
+		return false
+	}
+	var col string
+	var values []string
+	for _, a := range n.args {
+		switch a.name {
+		case "value":
+			c, ok := column(a.value, param)
+			if !ok {
+				return false
+			}
+			col = c
+		case "set":
+			arr, ok := a.value.(*arrayNode)
+			if !ok {
+				return false
+			}
+			values = []string{}
+			for _, e := range arr.elements {
+				str, ok := e.(*stringNode)
+				if !ok {
+					return false
+				}
+				values = append(values, str.value)
+			}
+		}
+	}
+	if col == "" || len(values) == 0 {
+		return false
+	}
+	var slot *[]string
+	switch col {
+	case "_measurement":
+		slot = &f.MeasurementNotIn
+		if in {
+			slot = &f.MeasurementIn
+		}
+	case "_field":
+		slot = &f.FieldNotIn
+		if in {
+			slot = &f.FieldIn
+		}
+	default:
+		op := filter.TagNotIn
+		if in {
+			op = filter.TagIn
+		}
+		f.Tags = append(f.Tags, &filter.TagPredicate{Key: col, Op: op, Values: values})
+		return true
+	}
+	if *slot != nil {
+		return false
+	}
+	*slot = values
+	return true
+}
+
+// comparison converts a comparison of a column with a literal.
+func comparison(col string, n *binaryNode) (*filter.Comparison, bool) {
+	op := filter.CompareOp(n.op)
+	switch op {
+	case filter.OpEq, filter.OpNEQ, filter.OpLT, filter.OpLTE, filter.OpGT, filter.OpGTE:
+	default:
+		return nil, false
+	}
+	var v filter.Operand
+	switch r := n.right.(type) {
+	case *stringNode:
+		v = filter.StringValue(r.value)
+	case *intNode:
+		v = filter.IntValue(r.value)
+	case *floatNode:
+		v = filter.FloatValue(r.value)
+	case *timeNode:
+		v = filter.TimeValue(r.value)
+	case *identNode:
+		if r.name != "true" && r.name != "false" {
+			return nil, false
+		}
+		v = filter.BoolValue(r.name == "true")
+	case *unaryNode:
+		switch o := r.operand.(type) {
+		case *intNode:
+			v = filter.IntValue(-o.value)
+		case *floatNode:
+			v = filter.FloatValue(-o.value)
+		default:
+			return nil, false
+		}
+		if r.op != "-" {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	if col == "_value" {
+		col = ""
+	}
+	c := &filter.Comparison{Column: col, Op: op, Value: v}
+	return c, c.Validate() == nil
+}
+
+var binaryOps = map[string]expression.Operator{
+	"==": expression.OpEq, "!=": expression.OpNEQ, "<": expression.OpLT, "<=": expression.OpLTE,
+	">": expression.OpGT, ">=": expression.OpGTE, "=~": expression.OpMatch, "!~": expression.OpNMatch,
+	"+": expression.OpAdd, "-": expression.OpSub, "*": expression.OpMul, "/": expression.OpDiv,
+	"%": expression.OpMod, "^": expression.OpPow,
+}
+
+// toExpr converts n into an expression.Expr, renaming the record param to r.
+func toExpr(n node, param string) (expression.Expr, error) {
+	switch n := n.(type) {
+	case *stringNode:
+		return expression.String(n.value), nil
+	case *intNode:
+		return expression.Int(n.value), nil
+	case *floatNode:
+		return expression.Float(n.value), nil
+	case *durationNode:
+		return expression.Duration(n.value), nil
+	case *timeNode:
+		return expression.Time(n.value), nil
+	case *regexNode:
+		return expression.Regex(n.pattern), nil
+	case *identNode:
+		switch n.name {
+		case param:
+			return expression.Ident("r"), nil
+		case "true":
+			return expression.Bool(true), nil
+		case "false":
+			return expression.Bool(false), nil
+		}
+		return expression.Ident(n.name), nil
+	case *memberNode:
+		if col, ok := column(n, param); ok {
+			return expression.Col(col), nil
+		}
+		if name := calleeName(n); name != "" && !strings.HasPrefix(name, param+".") {
+			return expression.Ident(name), nil
+		}
+	case *arrayNode:
+		elements := make([]expression.Expr, 0, len(n.elements))
+		for _, e := range n.elements {
+			x, err := toExpr(e, param)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, x)
+		}
+		return expression.Array(elements...), nil
+	case *binaryNode:
+		left, err := toExpr(n.left, param)
+		if err != nil {
+			return nil, err
+		}
+		right, err := toExpr(n.right, param)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "and":
+			return expression.And(left, right), nil
+		case "or":
+			return expression.Or(left, right), nil
+		}
+		return &expression.Binary{Op: binaryOps[n.op], Left: left, Right: right}, nil
+	case *unaryNode:
+		operand, err := toExpr(n.operand, param)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "not":
+			return expression.Not(operand), nil
+		case "exists":
+			return expression.Exists(operand), nil
+		case "-":
+			return expression.Neg(operand), nil
+		}
+		return operand, nil
+	case *callNode:
+		name := calleeName(n.callee)
+		if name == "" {
+			break
+		}
+		args := make([]expression.Arg, 0, len(n.args))
+		for _, a := range n.args {
+			v, err := toExpr(a.value, param)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, expression.Named(a.name, v))
+		}
+		return expression.Call(name, args...), nil
+	case *conditionalNode:
+		test, err := toExpr(n.test, param)
+		if err != nil {
+			return nil, err
+		}
+		consequent, err := toExpr(n.consequent, param)
+		if err != nil {
+			return nil, err
+		}
+		alternate, err := toExpr(n.alternate, param)
+		if err != nil {
+			return nil, err
+		}
+		return expression.If(test, consequent, alternate), nil
+	case *recordNode:
+		props := make([]expression.Property, 0, len(n.properties))
+		for _, p := range n.properties {
+			v, err := toExpr(p.value, param)
+			if err != nil {
+				return nil, err
+			}
+			props = append(props, expression.Prop(p.key, v))
+		}
+		if n.with == nil {
+			return expression.Record(props...), nil
+		}
+		base, err := toExpr(n.with, param)
+		if err != nil {
+			return nil, err
+		}
+		return expression.With(base, props...), nil
+	case *functionNode:
+		for _, p := range n.params {
+			if p == param || p == "r" {
+				return nil, fmt.Errorf("nested function shadows %s", p)
+			}
+		}
+		// references to the outer parameter are renamed to r as well
+		body, err := toExpr(n.body, param)
+		if err != nil {
+			return nil, err
+		}
+		return expression.Lambda(n.params, body), nil
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
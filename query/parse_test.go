@@ -0,0 +1,125 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ThinkontrolSY/flux-builder/filter"
+)
+
+func TestParse(t *testing.T) {
+	script := `import "timezone"
+import "influxdata/influxdb/schema"
+
+option location = timezone.location(name: "Asia/Shanghai")
+
+// soil sensors on the gateways
+from(bucket: "argiculture")
+  |> range(start: date.truncate(t: now(), unit: 1d), stop: v.timeRangeStop)
+  |> filter(fn: (r) => r._measurement == "sensor" and (r._field == "temp" or r._field =~ /moist.*/))
+  |> filter(fn: (row) => row["device-id"] =~ /gw-\d+/ and not exists row.site and row._value > 10.5)
+  |> filter(fn: (r) => strings.hasPrefix(v: r.host, prefix: "edge"))
+  |> aggregateWindow(every: v.windowPeriod, fn: mean, createEmpty: false)
+  |> map(fn: (r) => ({r with _value: r._value * 2.0}))
+  |> schema.fieldsAsCols()
+  |> filter(fn: (r) => r.temp > 0)
+  |> yield(name: "mean")`
+
+	q, err := Parse(script)
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) || len(unsupported.Constructs) != 1 {
+		t.Fatalf("expected the aggregateWindow every variable to be reported, got %v", err)
+	}
+	if q.Start.Kind != TruncateBound || q.Stop.Kind != VariableBound || len(q.Filters) != 3 || len(q.Transforms) != 5 {
+		t.Fatalf("unexpected query: %+v", q)
+	}
+
+	q.Transforms = q.Transforms[1:]
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `import "date"
+import "influxdata/influxdb/schema"
+import "strings"
+import "timezone"
+option location = timezone.location(name: "Asia/Shanghai")
+from(bucket: "argiculture")
+|> range(start: date.truncate(t: now(), unit: 1d), stop: v.timeRangeStop)
+|> filter(fn: (r) => (r._field == "temp" or r._field =~ /moist.*/) and r._measurement == "sensor")
+|> filter(fn: (r) => r["device-id"] =~ /gw-\d+/ and not exists r.site and r._value > 10.5)
+|> filter(fn: (r) => strings.hasPrefix(v: r.host, prefix: "edge"))
+|> map(fn: (r) => ({r with _value: r._value * 2.0}))
+|> schema.fieldsAsCols()
+|> filter(fn: (r) => r.temp > 0)
+|> yield(name: "mean")`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+
+	q, err = Parse(`from(bucket: "b") |> range(start: -1h) |> filter(fn: (r) => r._value == 2 * 3 ^ 2 and r._value != (2 * 3) ^ 2 and r._value < 2 ^ 3 ^ 2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flux, err = q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `from(bucket: "b")
+|> range(start: -1h)
+|> filter(fn: (r) => r._value != (2 * 3) ^ 2 and r._value < 2 ^ 3 ^ 2 and r._value == 2 * 3 ^ 2)`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, script := range []string{
+		`from(bucket: "b") |> range(start: -1h) |> filter(fn: (r) => r.host == "a${x}")`,
+		`buckets()`,
+		`from(bucket: "b") |> range(start: -1h`,
+	} {
+		if _, err := Parse(script); err == nil || errors.As(err, new(*UnsupportedError)) {
+			t.Errorf("expected a syntax error for %s, got %v", script, err)
+		}
+	}
+}
+
+func TestParse_Sets(t *testing.T) {
+	q, err := Parse(`from(bucket: "b") |> range(start: -1h) |> filter(fn: (r) => not contains(value: r._field, set: ["a", "b"]) and contains(value: r.host, set: ["h"]))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := q.Filters[0]
+	if strings.Join(f.FieldNotIn, ",") != "a,b" || len(f.Tags) != 1 || f.Tags[0].Key != "host" || f.Tags[0].Op != filter.TagIn || strings.Join(f.Tags[0].Values, ",") != "h" {
+		t.Errorf("unexpected filter: %+v", f)
+	}
+}
+
+func TestParse_References(t *testing.T) {
+	q, err := Parse(`from(bucket: "b")
+  |> range(start: -1h)
+  |> map(fn: (row) => ({row with f: (x) => x * row._value}))
+  |> aggregateWindow(every: 5m, fn: mean)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `from(bucket: "b")
+|> range(start: -1h)
+|> map(fn: (r) => ({r with f: (x) => x * r._value}))
+|> aggregateWindow(fn: mean, every: 5m)`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+
+	_, err = Parse(`from(bucket: "b") |> range(start: -1h) |> limit(n: myLimit) |> group(columns: [a, "b"])`)
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) || len(unsupported.Constructs) != 2 {
+		t.Errorf("expected the variable arguments to be reported, got %v", err)
+	}
+}
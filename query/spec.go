@@ -0,0 +1,176 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	"github.com/ThinkontrolSY/flux-builder/literal"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+// SpecVersion is the version of the JSON document written by Marshal.
+const SpecVersion = 1
+
+// Spec is the JSON document of a FluxQuery. Transforms are stored as the
+// {fn, params} inputs TransformInput decodes.
+type Spec struct {
+	Version         int                    `json:"version"`
+	Bucket          string                 `json:"bucket"`
+	Timezone        *string                `json:"timezone,omitempty"`
+	Start           *TimeBound             `json:"start,omitempty"`
+	Stop            *TimeBound             `json:"stop,omitempty"`
+	Filters         []*filter.FluxFilter   `json:"filters,omitempty"`
+	Transforms      []*pipe.TransformInput `json:"transforms,omitempty"`
+	Params          map[string]interface{} `json:"params,omitempty"`
+	Parameterize    bool                   `json:"parameterize,omitempty"`
+	OptimizeFilters bool                   `json:"optimizeFilters,omitempty"`
+}
+
+// Spec returns the JSON document of the query.
+func (q *FluxQuery) Spec() (*Spec, error) {
+	s := &Spec{
+		Version:         SpecVersion,
+		Bucket:          q.Bucket,
+		Timezone:        q.Timezone,
+		Start:           q.Start,
+		Stop:            q.Stop,
+		Params:          q.Params,
+		Parameterize:    q.Parameterize,
+		OptimizeFilters: q.OptimizeFilters,
+	}
+	for _, f := range q.Filters {
+		if f != nil {
+			s.Filters = append(s.Filters, f)
+		}
+	}
+	for i, t := range q.Transforms {
+		if t == nil {
+			continue
+		}
+		input, err := pipe.Input(t)
+		if err != nil {
+			return nil, fmt.Errorf("transforms[%d]: %w", i, err)
+		}
+		s.Transforms = append(s.Transforms, input)
+	}
+	return s, nil
+}
+
+// Query builds the FluxQuery described by the document.
+func (s *Spec) Query() (*FluxQuery, error) {
+	if s.Version != SpecVersion {
+		return nil, fmt.Errorf("unsupported spec version: %d", s.Version)
+	}
+	q := &FluxQuery{
+		Bucket:          s.Bucket,
+		Timezone:        s.Timezone,
+		Start:           s.Start,
+		Stop:            s.Stop,
+		Filters:         s.Filters,
+		Params:          s.Params,
+		Parameterize:    s.Parameterize,
+		OptimizeFilters: s.OptimizeFilters,
+	}
+	for i, input := range s.Transforms {
+		if input == nil {
+			continue
+		}
+		t, err := input.Transform()
+		if err != nil {
+			return nil, fmt.Errorf("transforms[%d]: %w", i, err)
+		}
+		q.Transforms = append(q.Transforms, t)
+	}
+	return q, nil
+}
+
+// Marshal writes the query as a versioned JSON document.
+func Marshal(q *FluxQuery) ([]byte, error) {
+	s, err := q.Spec()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range s.Transforms {
+		t.Params = jsonNumbers(t.Params).(map[string]interface{})
+	}
+	s.Params = jsonNumbers(s.Params).(map[string]interface{})
+	return json.Marshal(s)
+}
+
+// Unmarshal reads a document written by Marshal.
+func Unmarshal(data []byte) (*FluxQuery, error) {
+	var s Spec
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&s); err != nil {
+		return nil, err
+	}
+	for _, t := range s.Transforms {
+		if t != nil {
+			t.Params = goNumbers(t.Params).(map[string]interface{})
+		}
+	}
+	s.Params = goNumbers(s.Params).(map[string]interface{})
+	return s.Query()
+}
+
+// jsonNumbers writes floats with a decimal point, so that untyped values
+// such as the fill value keep their Flux type through JSON.
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = jsonNumbers(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			s = append(s, jsonNumbers(e))
+		}
+		return s
+	case float64:
+		if f, err := literal.Float(v); err == nil {
+			return json.Number(f)
+		}
+	case float32:
+		return jsonNumbers(float64(v))
+	}
+	return v
+}
+
+// goNumbers is the reverse of jsonNumbers.
+func goNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		for k, e := range v {
+			v[k] = goNumbers(e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = goNumbers(e)
+		}
+		return v
+	case json.Number:
+		if !strings.ContainsAny(string(v), ".eE") {
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+	return v
+}
@@ -0,0 +1,193 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/ThinkontrolSY/flux-builder/filter"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+func TestSpec_RoundTrip(t *testing.T) {
+	tz := "Asia/Shanghai"
+	host, re, yes := "gw-1", filter.Regex("edge-.*"), false
+	d, column, n := pipe.Duration("1h"), "_value", 2
+	method, mode := pipe.EstimateTdigest, pipe.StddevModeSample
+	compression, value := 1000.0, 0.0
+	q := &FluxQuery{
+		Bucket:   "b",
+		Timezone: &tz,
+		Start:    At(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Stop:     Truncate("1d").Shift("-1h"),
+		Filters: []*filter.FluxFilter{
+			{
+				Or: []*filter.FluxFilter{
+					{Tags: []*filter.TagPredicate{{Key: host, Op: filter.TagEq, Value: host}}},
+					{Tags: []*filter.TagPredicate{{Key: host, Op: filter.TagMatch, Regex: re}, {Key: host, Op: filter.TagNotExists}}},
+				},
+				Expr: expression.Call("strings.hasPrefix", expression.Named("v", expression.Col("host")), expression.Named("prefix", expression.String("gw"))),
+			},
+		},
+		Params: map[string]interface{}{"threshold": 1.0},
+		Transforms: []pipe.TransformPipe{
+			&pipe.AggregatorPipe{Every: "5m", Fn: pipe.Mean, CreateEmpty: &yes, Period: &d},
+			&pipe.BottomPipe{N: 3, Columns: []string{"a"}},
+			&pipe.TopPipe{N: 3},
+			&pipe.CountPipe{Column: &column},
+			&pipe.CumulativeSumPipe{},
+			&pipe.DerivativePipe{Unit: &d, NonNegative: &yes},
+			&pipe.DifferencePipe{Columns: []string{"a", "b"}},
+			&pipe.DistinctPipe{},
+			&pipe.DoubleEMAPipe{N: 5},
+			&pipe.ElapsedPipe{Unit: &d},
+			&pipe.ExponentialMovingAveragePipe{N: 5},
+			&pipe.FieldsAsColsPipe{},
+			&pipe.FillPipe{Value: value},
+			&pipe.FillPipe{Value: int64(1), Column: &column},
+			&pipe.FilterPipe{Lambda: expression.Fn(expression.GT(expression.Col("_value"), expression.Param("threshold")))},
+			&pipe.FirstPipe{},
+			&pipe.GroupPipe{Columns: []string{"host"}},
+			&pipe.LastPipe{},
+			&pipe.IncreasePipe{},
+			&pipe.IntegralPipe{Unit: "1s"},
+			&pipe.KaufmansAMAPipe{N: 10},
+			&pipe.KaufmansERPipe{N: 10},
+			&pipe.LimitPipe{N: 10, Offset: &n},
+			&pipe.MapPipe{Fields: []pipe.MapField{{Column: "_value", Value: expression.Mul(expression.Col("_value"), expression.Float(2))}}},
+			&pipe.MaxPipe{},
+			&pipe.MeanPipe{},
+			&pipe.MedianPipe{Method: &method, Compression: &compression},
+			&pipe.MinPipe{},
+			&pipe.ModePipe{},
+			&pipe.MovingAveragePipe{N: 3},
+			&pipe.PivotPipe{RowKey: []string{"_time"}, ColumnKey: []string{"_field"}, ValueColumn: "_value"},
+			&pipe.QuantilePipe{Q: 0.99},
+			&pipe.RelativeStrengthIndexPipe{N: 14},
+			&pipe.SkewPipe{},
+			&pipe.SpreadPipe{},
+			&pipe.SortPipe{Columns: []string{"_value"}, Desc: &yes},
+			&pipe.StddevPipe{Mode: &mode},
+			&pipe.StateCountPipe{Fn: `(r) => r._value > 80`},
+			&pipe.StateTrackingPipe{Fn: `(r) => r._value > 80`, DurationUnit: &d},
+			&pipe.SumPipe{},
+			&pipe.TimeShiftPipe{Duration: "-1h"},
+			&pipe.StateDurationPipe{Fn: `(r) => r._value > 80`},
+			&pipe.TailPipe{N: 1},
+			&pipe.TimeMovingAveragePipe{Every: "1m", Period: "5m"},
+			&pipe.TimeWeightedAvgPipe{Unit: "1m"},
+			&pipe.KeepPipe{Columns: []string{"_time", "_value"}},
+			&pipe.DropPipe{Columns: []string{"host"}},
+			&pipe.ToBoolPipe{},
+			&pipe.ToFloatPipe{},
+			&pipe.ToIntPipe{},
+			&pipe.ToStringPipe{},
+			&pipe.ToTimePipe{},
+			&pipe.ToUIntPipe{},
+			&pipe.TripleEMAPipe{N: 5},
+			&pipe.TripleExponentialDerivativePipe{N: 5},
+			&pipe.TruncateTimeColumnPipe{Unit: "1m"},
+			&pipe.UniquePipe{},
+			&pipe.WindowPipe{Every: &d},
+			&pipe.YieldPipe{},
+		},
+	}
+	expected, params, err := q.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"value":0.0`) {
+		t.Errorf("expected the fill value to stay a float: %s", data)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flux, decodedParams, err := decoded.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flux != expected {
+		t.Errorf("round trip changed the script:\n%s\nexpected:\n%s", flux, expected)
+	}
+	if decodedParams["threshold"] != params["threshold"] {
+		t.Errorf("round trip changed the params: %v", decodedParams)
+	}
+
+	if _, err := Unmarshal([]byte(`{"version": 2, "bucket": "b"}`)); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+type scalePipe struct {
+	Factor float64
+}
+
+func (a *scalePipe) Pipe() (string, error) {
+	return fmt.Sprintf("|> map(fn: (r) => ({r with _value: r._value * %g}))", a.Factor), nil
+}
+
+// scalePipe is registered once for the package, registrations cannot be
+// undone.
+func init() {
+	pipe.MustRegister(pipe.Registration{Name: "scale", New: func() pipe.TransformPipe { return &scalePipe{} }})
+}
+
+func TestSpec_RegisteredTransform(t *testing.T) {
+	if err := pipe.Register(pipe.Registration{Name: "scale", New: func() pipe.TransformPipe { return &scalePipe{} }}); err == nil {
+		t.Error("expected an error for a duplicate registration")
+	}
+
+	data := []byte(`{"version": 1, "bucket": "b", "start": {"kind": "relative", "duration": "-1h"}, "transforms": [{"fn": "scale", "params": {"factor": 2.5}}]}`)
+	q, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := q.Transforms[0].(*scalePipe); !ok || s.Factor != 2.5 {
+		t.Fatalf("unexpected transform: %#v", q.Transforms[0])
+	}
+	encoded, err := Marshal(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `{"fn":"scale","params":{"factor":2.5}}`) {
+		t.Errorf("unexpected spec: %s", encoded)
+	}
+}
+
+func TestSpec_Filters(t *testing.T) {
+	var sensors []string
+	for i := 0; i < 12; i++ {
+		sensors = append(sensors, fmt.Sprintf("s%d", i))
+	}
+	data := `{"version": 1, "bucket": "b", "start": {"kind": "relative", "duration": "-1h"}, "filters": [
+		{"measurementIn": ["cpu", "mem"], "fieldNotIn": ["idle"], "tagKey": "sensor", "tagIn": ["` + strings.Join(sensors, `", "`) + `"]},
+		{"value": ">= 10.5"}
+	]}`
+	q, err := Unmarshal([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `from(bucket: "b")
+|> range(start: -1h)
+|> filter(fn: (r) => (r._measurement == "cpu" or r._measurement == "mem") and r._field != "idle" and contains(value: r.sensor, set: ["s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7", "s8", "s9", "s10", "s11"]))
+|> filter(fn: (r) => r._value >= 10.5)`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+
+	if _, err := Unmarshal([]byte(`{"version": 1, "bucket": "b", "filters": [{"tagKey": "host", "tagMatch": "gw-("}]}`)); err == nil {
+		t.Error("expected an error for an invalid regex in a filter")
+	}
+}
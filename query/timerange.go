@@ -0,0 +1,251 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/ThinkontrolSY/flux-builder/literal"
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+type BoundKind string
+
+const (
+	// AbsoluteBound is a fixed point in time, rendered as RFC3339Nano.
+	AbsoluteBound BoundKind = "time"
+	// RelativeBound is a duration relative to now(), e.g. -1h.
+	RelativeBound BoundKind = "relative"
+	NowBound      BoundKind = "now"
+	// TodayBound is midnight of the current day in the query location.
+	TodayBound BoundKind = "today"
+	// TruncateBound is now() truncated to Unit, e.g. the start of the month.
+	TruncateBound BoundKind = "truncate"
+	// VariableBound references a time defined outside of the script, such as
+	// the dashboard variable v.timeRangeStart.
+	VariableBound BoundKind = "variable"
+)
+
+// TimeBound is the start or the stop of the range of a FluxQuery.
+type TimeBound struct {
+	Kind     BoundKind     `json:"kind"`
+	Time     time.Time     `json:"time"`
+	Duration pipe.Duration `json:"duration,omitempty"`
+	Unit     pipe.Duration `json:"unit,omitempty"`
+	Variable string        `json:"variable,omitempty"`
+	// Offset shifts a now, today or truncate bound with date.add, e.g. the
+	// start of the previous month is Truncate("1mo").Shift("-1mo").
+	Offset *pipe.Duration `json:"offset,omitempty"`
+}
+
+// MarshalJSON leaves out the zero time of bounds that are not absolute.
+func (b TimeBound) MarshalJSON() ([]byte, error) {
+	type timeBound TimeBound
+	var t *time.Time
+	if b.Kind == AbsoluteBound {
+		t = &b.Time
+	}
+	return json.Marshal(struct {
+		timeBound
+		Time *time.Time `json:"time,omitempty"`
+	}{timeBound(b), t})
+}
+
+func At(t time.Time) *TimeBound {
+	return &TimeBound{Kind: AbsoluteBound, Time: t}
+}
+
+func Relative(d pipe.Duration) *TimeBound {
+	return &TimeBound{Kind: RelativeBound, Duration: d}
+}
+
+func Now() *TimeBound {
+	return &TimeBound{Kind: NowBound}
+}
+
+func Today() *TimeBound {
+	return &TimeBound{Kind: TodayBound}
+}
+
+func Truncate(unit pipe.Duration) *TimeBound {
+	return &TimeBound{Kind: TruncateBound, Unit: unit}
+}
+
+func Variable(name string) *TimeBound {
+	return &TimeBound{Kind: VariableBound, Variable: name}
+}
+
+// Shift returns a copy of b moved by d.
+func (b *TimeBound) Shift(d pipe.Duration) *TimeBound {
+	shifted := *b
+	shifted.Offset = &d
+	return &shifted
+}
+
+// ParseBound reads the textual forms accepted by range(): an RFC3339 time, a
+// duration, now() or today().
+func ParseBound(s string) (*TimeBound, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "now()":
+		return Now(), nil
+	case "today()":
+		return Today(), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return At(t), nil
+	}
+	if d := pipe.Duration(s); d.Error() == nil {
+		return Relative(d), nil
+	}
+	return nil, fmt.Errorf("invalid range bound: %q, expected an RFC3339 time, a duration, now() or today()", s)
+}
+
+func (b *TimeBound) Imports() []string {
+	if b.Kind == TruncateBound || b.Offset != nil {
+		return []string{"date"}
+	}
+	return nil
+}
+
+func (b *TimeBound) flux(binder *literal.Binder) (string, error) {
+	var base string
+	switch b.Kind {
+	case AbsoluteBound:
+		if b.Offset != nil {
+			return "", fmt.Errorf("offset is not supported on absolute bounds")
+		}
+		return binder.Time(b.Time), nil
+	case RelativeBound:
+		if err := b.Duration.Error(); err != nil {
+			return "", err
+		}
+		if b.Offset != nil {
+			return "", fmt.Errorf("offset is not supported on relative bounds")
+		}
+		return string(b.Duration), nil
+	case VariableBound:
+		if b.Offset != nil {
+			return "", fmt.Errorf("offset is not supported on variable bounds")
+		}
+		return expression.Ident(b.Variable).Expr()
+	case NowBound:
+		base = "now()"
+	case TodayBound:
+		base = "today()"
+	case TruncateBound:
+		if err := b.Unit.Error(); err != nil {
+			return "", err
+		}
+		base = fmt.Sprintf("date.truncate(t: now(), unit: %s)", b.Unit)
+	default:
+		return "", fmt.Errorf("invalid range bound kind: %q", b.Kind)
+	}
+	if b.Offset == nil {
+		return base, nil
+	}
+	if err := b.Offset.Error(); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(string(*b.Offset), "-") {
+		return fmt.Sprintf("date.sub(d: %s, from: %s)", strings.TrimPrefix(string(*b.Offset), "-"), base), nil
+	}
+	return fmt.Sprintf("date.add(d: %s, to: %s)", *b.Offset, base), nil
+}
+
+// resolve evaluates the bound at now in loc, the way the server would. It
+// reports false for bounds it cannot evaluate.
+func (b *TimeBound) resolve(now time.Time, loc *time.Location) (time.Time, bool) {
+	var t time.Time
+	switch b.Kind {
+	case AbsoluteBound:
+		return b.Time, true
+	case RelativeBound:
+		return addDuration(now, b.Duration)
+	case NowBound:
+		t = now
+	case TodayBound:
+		n := now.In(loc)
+		t = time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, loc)
+	case TruncateBound:
+		var ok bool
+		if t, ok = truncate(now.In(loc), b.Unit); !ok {
+			return time.Time{}, false
+		}
+	default:
+		return time.Time{}, false
+	}
+	if b.Offset != nil {
+		return addDuration(t, *b.Offset)
+	}
+	return t, true
+}
+
+func addDuration(t time.Time, d pipe.Duration) (time.Time, bool) {
+	t, err := d.AddTo(t)
+	return t, err == nil
+}
+
+func truncate(t time.Time, unit pipe.Duration) (time.Time, bool) {
+	loc := t.Location()
+	switch unit {
+	case "1y":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc), true
+	case "1mo":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc), true
+	case "1w":
+		// weeks start on Thursday, the weekday of the Unix epoch
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 3) % 7)), true
+	case "1d":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc), true
+	case "1h":
+		return t.Truncate(time.Hour), true
+	case "1m":
+		return t.Truncate(time.Minute), true
+	case "1s":
+		return t.Truncate(time.Second), true
+	}
+	return time.Time{}, false
+}
+
+// rangePipe renders range() and checks that start is before stop whenever
+// both bounds can be evaluated.
+func rangePipe(start, stop *TimeBound, timezone *string, binder *literal.Binder) (string, error) {
+	if start == nil && stop == nil {
+		return "", fmt.Errorf("start and stop are required")
+	}
+	if start != nil && stop != nil {
+		loc := time.UTC
+		if timezone != nil {
+			if l, err := time.LoadLocation(*timezone); err == nil {
+				loc = l
+			}
+		}
+		now := time.Now()
+		startTime, startOk := start.resolve(now, loc)
+		stopTime, stopOk := stop.resolve(now, loc)
+		if startOk && stopOk && !startTime.Before(stopTime) {
+			return "", fmt.Errorf("range start %s must be before stop %s", startTime.Format(time.RFC3339), stopTime.Format(time.RFC3339))
+		}
+	}
+
+	var params []string
+	if start != nil {
+		s, err := start.flux(binder)
+		if err != nil {
+			return "", fmt.Errorf("range start: %w", err)
+		}
+		params = append(params, fmt.Sprintf("start: %s", s))
+	}
+	if stop != nil {
+		s, err := stop.flux(binder)
+		if err != nil {
+			return "", fmt.Errorf("range stop: %w", err)
+		}
+		params = append(params, fmt.Sprintf("stop: %s", s))
+	}
+	return fmt.Sprintf("|> range(%s)", strings.Join(params, ", ")), nil
+}
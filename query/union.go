@@ -0,0 +1,92 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+// UnionQuery merges several FluxQuery sources, each with its own bucket,
+// range and filters, into one stream before the shared Transforms run.
+type UnionQuery struct {
+	// Timezone sets the location option of the whole script. The sources
+	// cannot set their own, since Flux has a single location option.
+	Timezone   *string
+	Sources    []*FluxQuery
+	Transforms []pipe.TransformPipe
+
+	// Params and Parameterize work as in FluxQuery. Params of the sources are
+	// merged into Params and Parameterize applies to all of them.
+	Params       map[string]interface{}
+	Parameterize bool
+}
+
+func (q *UnionQuery) AddSource(s *FluxQuery) *UnionQuery {
+	if s != nil {
+		q.Sources = append(q.Sources, s)
+	}
+	return q
+}
+
+func (q *UnionQuery) AddTransform(f pipe.TransformPipe) *UnionQuery {
+	if f != nil {
+		q.Transforms = append(q.Transforms, f)
+	}
+	return q
+}
+
+func (q *UnionQuery) QueryString() (string, error) {
+	flux, _, err := q.Build()
+	return flux, err
+}
+
+// Build renders the script together with the params it references.
+func (q *UnionQuery) Build() (string, map[string]interface{}, error) {
+	sets := []map[string]interface{}{q.Params}
+	for _, s := range q.Sources {
+		if s != nil {
+			sets = append(sets, s.Params)
+		}
+	}
+	params, b, err := bindParams(q.Parameterize, sets...)
+	if err != nil {
+		return "", nil, err
+	}
+	var imports []string
+	for _, s := range q.Sources {
+		if s != nil {
+			imports = append(imports, s.imports()...)
+		}
+	}
+	pipes := header(q.Timezone, append(imports, transformImports(q.Transforms)...)...)
+
+	var tables []string
+	for _, s := range q.Sources {
+		if s == nil {
+			continue
+		}
+		name := fmt.Sprintf("source%d", len(tables))
+		if s.Timezone != nil {
+			return "", nil, fmt.Errorf("%s: timezone can only be set on the union query", name)
+		}
+		body, err := s.pipeline(b)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", name, err)
+		}
+		body[0] = fmt.Sprintf("%s = %s", name, body[0])
+		pipes = append(pipes, body...)
+		tables = append(tables, name)
+	}
+	if len(tables) < 2 {
+		return "", nil, fmt.Errorf("union requires at least two sources")
+	}
+	pipes = append(pipes, fmt.Sprintf("union(tables: [%s])", strings.Join(tables, ", ")))
+
+	transforms, err := transformPipes(q.Transforms)
+	if err != nil {
+		return "", nil, err
+	}
+	pipes = append(pipes, transforms...)
+	return strings.Join(pipes, "\n"), params, nil
+}
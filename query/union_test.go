@@ -0,0 +1,41 @@
+package query
+
+import (
+	"testing"
+
+	pipe "github.com/ThinkontrolSY/flux-builder/transformpipe"
+)
+
+func TestUnionQuery_QueryString(t *testing.T) {
+	q := &UnionQuery{}
+	q.AddSource(measurementQuery("site-a", "measure-sensor", "SoilTemperature")).
+		AddSource(measurementQuery("site-b", "measure-sensor", "SoilTemperature")).
+		AddTransform(&pipe.AggregatorPipe{Every: "1h", Fn: pipe.Mean})
+
+	flux, err := q.QueryString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `source0 = from(bucket: "site-a")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "measure-sensor" and r._field == "SoilTemperature")
+source1 = from(bucket: "site-b")
+|> range(start: -1d)
+|> filter(fn: (r) => r._measurement == "measure-sensor" and r._field == "SoilTemperature")
+union(tables: [source0, source1])
+|> aggregateWindow(fn: mean, every: 1h)`
+	if flux != expected {
+		t.Errorf("unexpected flux:\n%s", flux)
+	}
+
+	tz := "UTC"
+	q.Sources[1].Timezone = &tz
+	if _, err := q.QueryString(); err == nil {
+		t.Error("expected an error for a source timezone")
+	}
+
+	q.Sources = q.Sources[:1]
+	if _, err := q.QueryString(); err == nil {
+		t.Error("expected error for a single source")
+	}
+}
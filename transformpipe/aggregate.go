@@ -3,6 +3,8 @@ package transformpipe
 import (
 	"fmt"
 	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/literal"
 )
 
 type TransformFn string
@@ -40,6 +42,15 @@ const (
 	Unique TransformFn = "unique"
 )
 
+// Values lists the aggregate functions, see JSONSchema.
+func (TransformFn) Values() []string {
+	return []string{
+		string(Mean), string(Min), string(Max), string(Sum), string(Count), string(Stddev), string(Median),
+		string(First), string(Last), string(Integral), string(Mode), string(Skew), string(Spread),
+		string(Distinct), string(Unique),
+	}
+}
+
 type AggregatorPipe struct {
 	/*
 		Duration of windows.
@@ -75,36 +86,27 @@ type AggregatorPipe struct {
 }
 
 func (a *AggregatorPipe) Pipe() (string, error) {
-	var params []string
-	params = append(params, fmt.Sprintf("fn: %s", a.Fn))
-	if err := a.Every.Error(); err != nil {
+	if err := a.Validate(); err != nil {
 		return "", err
-	} else {
-		params = append(params, fmt.Sprintf("every: %s", a.Every))
 	}
+	var params []string
+	params = append(params, fmt.Sprintf("fn: %s", a.Fn))
+	params = append(params, fmt.Sprintf("every: %s", a.Every))
 	if a.Offset != nil {
-		if err := a.Offset.Error(); err != nil {
-			return "", err
-		} else {
-			params = append(params, fmt.Sprintf("offset: %s", *a.Offset))
-		}
+		params = append(params, fmt.Sprintf("offset: %s", *a.Offset))
 
 	}
 	if a.Period != nil {
-		if err := a.Period.Error(); err != nil {
-			return "", err
-		} else {
-			params = append(params, fmt.Sprintf("period: %s", *a.Period))
-		}
+		params = append(params, fmt.Sprintf("period: %s", *a.Period))
 	}
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf("column: \"%s\"", *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	if a.TimeSrc != nil {
-		params = append(params, fmt.Sprintf("timeSrc: \"%s\"", *a.TimeSrc))
+		params = append(params, fmt.Sprintf("timeSrc: %s", literal.String(*a.TimeSrc)))
 	}
 	if a.TimeDst != nil {
-		params = append(params, fmt.Sprintf("timeDst: \"%s\"", *a.TimeDst))
+		params = append(params, fmt.Sprintf("timeDst: %s", literal.String(*a.TimeDst)))
 	}
 	if a.CreateEmpty != nil {
 		params = append(params, fmt.Sprintf("createEmpty: %t", *a.CreateEmpty))
@@ -0,0 +1,318 @@
+package transformpipe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a Flux duration literal such as 1h30m, -1mo or 1y2w. It stays a
+// string so that it is written as is to Flux, JSON and mapstructure input.
+type Duration string
+
+// DurationPart is one magnitude and unit of a duration, e.g. 30m.
+type DurationPart struct {
+	Magnitude int64
+	Unit      string
+}
+
+// ParsedDuration is a duration split into its parts. Flux keeps months and
+// years apart from the fixed units because their length depends on the
+// calendar.
+type ParsedDuration struct {
+	Negative bool
+	Parts    []DurationPart
+}
+
+// durationUnits lists the units longest first, so that mo and ms win over m.
+var durationUnits = []struct {
+	unit   string
+	length time.Duration
+	months int64
+}{
+	{"mo", 0, 1},
+	{"ms", time.Millisecond, 0},
+	{"us", time.Microsecond, 0},
+	{"µs", time.Microsecond, 0},
+	{"ns", time.Nanosecond, 0},
+	{"y", 0, 12},
+	{"w", 7 * 24 * time.Hour, 0},
+	{"d", 24 * time.Hour, 0},
+	{"h", time.Hour, 0},
+	{"m", time.Minute, 0},
+	{"s", time.Second, 0},
+}
+
+func (d Duration) Error() error {
+	if _, err := d.Parse(); err != nil {
+		return fmt.Errorf("invalid duration value: %s, duration should format with IMPL#2026", d)
+	}
+	return nil
+}
+
+// Parse splits d into its parts.
+func (d Duration) Parse() (ParsedDuration, error) {
+	var p ParsedDuration
+	s := string(d)
+	if strings.HasPrefix(s, "-") {
+		p.Negative = true
+		s = s[1:]
+	}
+	if s == "" {
+		return p, fmt.Errorf("empty duration")
+	}
+	for s != "" {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return p, fmt.Errorf("invalid duration %q: expected a magnitude", d)
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid duration %q: %w", d, err)
+		}
+		s = s[i:]
+		unit := ""
+		for _, u := range durationUnits {
+			if strings.HasPrefix(s, u.unit) {
+				unit = u.unit
+				break
+			}
+		}
+		if unit == "" {
+			return p, fmt.Errorf("invalid duration %q: expected a unit", d)
+		}
+		s = s[len(unit):]
+		if unit == "µs" {
+			unit = "us"
+		}
+		p.Parts = append(p.Parts, DurationPart{Magnitude: n, Unit: unit})
+	}
+	return p, nil
+}
+
+// Months returns the calendar part of the duration, with years as 12 months.
+func (p ParsedDuration) Months() int64 {
+	var months int64
+	for _, part := range p.Parts {
+		for _, u := range durationUnits {
+			if u.unit == part.Unit {
+				months += part.Magnitude * u.months
+			}
+		}
+	}
+	if p.Negative {
+		return -months
+	}
+	return months
+}
+
+// Fixed returns the part of the duration made of fixed length units. Days
+// and weeks are 24 and 168 hours, as in Flux.
+func (p ParsedDuration) Fixed() (time.Duration, error) {
+	var total time.Duration
+	for _, part := range p.Parts {
+		for _, u := range durationUnits {
+			if u.unit != part.Unit || u.length == 0 {
+				continue
+			}
+			if part.Magnitude > math.MaxInt64/int64(u.length) {
+				return 0, fmt.Errorf("duration overflows: %d%s", part.Magnitude, part.Unit)
+			}
+			v := time.Duration(part.Magnitude) * u.length
+			if total > math.MaxInt64-v {
+				return 0, fmt.Errorf("duration overflows")
+			}
+			total += v
+		}
+	}
+	if p.Negative {
+		return -total, nil
+	}
+	return total, nil
+}
+
+// TimeDuration converts d into a time.Duration. Durations with months or
+// years have no fixed length and cannot be converted.
+func (d Duration) TimeDuration() (time.Duration, error) {
+	p, err := d.Parse()
+	if err != nil {
+		return 0, err
+	}
+	if p.Months() != 0 {
+		return 0, fmt.Errorf("duration %s has calendar units", d)
+	}
+	return p.Fixed()
+}
+
+// FromDuration returns the literal of t using the largest units, e.g. 90m
+// becomes 1h30m and 48h becomes 2d.
+func FromDuration(t time.Duration) Duration {
+	if t == 0 {
+		return "0s"
+	}
+	return compose(0, t)
+}
+
+// compose writes months and a fixed part, which must have the same sign.
+func compose(months int64, fixed time.Duration) Duration {
+	var b strings.Builder
+	if months < 0 || fixed < 0 {
+		b.WriteByte('-')
+		months, fixed = -months, -fixed
+	}
+	if y := months / 12; y > 0 {
+		fmt.Fprintf(&b, "%dy", y)
+	}
+	if mo := months % 12; mo > 0 {
+		fmt.Fprintf(&b, "%dmo", mo)
+	}
+	for _, u := range []struct {
+		unit   string
+		length time.Duration
+	}{
+		{"w", 7 * 24 * time.Hour}, {"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute},
+		{"s", time.Second}, {"ms", time.Millisecond}, {"us", time.Microsecond}, {"ns", time.Nanosecond},
+	} {
+		if n := fixed / u.length; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, u.unit)
+			fixed -= n * u.length
+		}
+	}
+	if b.Len() == 0 || b.String() == "-" {
+		return "0s"
+	}
+	return Duration(b.String())
+}
+
+func (d Duration) components() (int64, time.Duration, error) {
+	p, err := d.Parse()
+	if err != nil {
+		return 0, 0, err
+	}
+	fixed, err := p.Fixed()
+	return p.Months(), fixed, err
+}
+
+// Add returns d + o. A Flux duration has a single sign, so adding for
+// example 1mo and -1d fails.
+func (d Duration) Add(o Duration) (Duration, error) {
+	m1, f1, err := d.components()
+	if err != nil {
+		return "", err
+	}
+	m2, f2, err := o.components()
+	if err != nil {
+		return "", err
+	}
+	months, fixed := m1+m2, f1+f2
+	if (f2 > 0 && fixed < f1) || (f2 < 0 && fixed > f1) {
+		return "", fmt.Errorf("duration overflows: %s + %s", d, o)
+	}
+	if (months > 0 && fixed < 0) || (months < 0 && fixed > 0) {
+		return "", fmt.Errorf("%s + %s mixes positive and negative units", d, o)
+	}
+	return compose(months, fixed), nil
+}
+
+// Neg returns -d.
+func (d Duration) Neg() Duration {
+	if strings.HasPrefix(string(d), "-") {
+		return d[1:]
+	}
+	return "-" + d
+}
+
+// Compare returns -1, 0 or 1 as d is shorter than, as long as or longer
+// than o. Months are between 28 and 31 days long, so durations such as 1mo
+// and 30d cannot be ordered and return an error.
+func (d Duration) Compare(o Duration) (int, error) {
+	m1, f1, err := d.components()
+	if err != nil {
+		return 0, err
+	}
+	m2, f2, err := o.components()
+	if err != nil {
+		return 0, err
+	}
+	months, fixed := m1-m2, f1-f2
+	if months == 0 {
+		return sign(int64(fixed)), nil
+	}
+	day := 24 * time.Hour
+	short, long := time.Duration(months)*28*day+fixed, time.Duration(months)*31*day+fixed
+	switch {
+	case short > 0 && long > 0:
+		return 1, nil
+	case short < 0 && long < 0:
+		return -1, nil
+	}
+	return 0, fmt.Errorf("durations %s and %s cannot be compared", d, o)
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// AddTo adds d to t the way Flux does: months first, then the fixed part.
+func (d Duration) AddTo(t time.Time) (time.Time, error) {
+	months, fixed, err := d.components()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, int(months), 0).Add(fixed), nil
+}
+
+// UnmarshalJSON accepts a duration literal or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*d = Duration(s)
+		return nil
+	}
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("duration must be a string or a number of nanoseconds: %s", data)
+	}
+	*d = FromDuration(time.Duration(n))
+	return nil
+}
+
+// Windows returns the number of windows of length d needed to cover
+// [start, stop). Windows with calendar units are counted on the calendar.
+func (d Duration) Windows(start, stop time.Time) (int64, error) {
+	months, fixed, err := d.components()
+	if err != nil {
+		return 0, err
+	}
+	if months < 0 || fixed < 0 || (months == 0 && fixed == 0) {
+		return 0, fmt.Errorf("window duration must be positive, got %s", d)
+	}
+	if !stop.After(start) {
+		return 0, nil
+	}
+	if months == 0 {
+		span := stop.Sub(start)
+		n := int64(span / fixed)
+		if span%fixed != 0 {
+			n++
+		}
+		return n, nil
+	}
+	var n int64
+	for t := start; t.Before(stop); n++ {
+		t = t.AddDate(0, int(months), 0).Add(fixed)
+	}
+	return n, nil
+}
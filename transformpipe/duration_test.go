@@ -0,0 +1,81 @@
+package transformpipe
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	for _, d := range []Duration{"", "-", "1", "h", "1x", "1h-2m", "99999999999999999999s"} {
+		if d.Error() == nil {
+			t.Errorf("expected %q to be invalid", d)
+		}
+	}
+
+	p, err := Duration("-1y2mo3d").Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Months() != -14 {
+		t.Errorf("expected -14 months, got %d", p.Months())
+	}
+	if fixed, _ := p.Fixed(); fixed != -72*time.Hour {
+		t.Errorf("expected -72h, got %s", fixed)
+	}
+
+	if td, err := Duration("1h30m").TimeDuration(); err != nil || td != 90*time.Minute {
+		t.Errorf("expected 90m, got %s, %v", td, err)
+	}
+	if _, err := Duration("1mo").TimeDuration(); err == nil {
+		t.Error("expected an error for calendar units")
+	}
+	if d := FromDuration(-(49*time.Hour + 1500*time.Microsecond)); d != "-2d1h1ms500us" {
+		t.Errorf("unexpected literal %s", d)
+	}
+
+	if d, err := Duration("1mo").Add("2w"); err != nil || d != "1mo2w" {
+		t.Errorf("expected 1mo2w, got %s, %v", d, err)
+	}
+	if d, err := Duration("1y").Add("-1mo"); err != nil || d != "11mo" {
+		t.Errorf("expected 11mo, got %s, %v", d, err)
+	}
+	if _, err := Duration("1mo").Add("-1d"); err == nil {
+		t.Error("expected an error for mixed signs")
+	}
+
+	for _, c := range []struct {
+		a, b Duration
+		want int
+	}{
+		{"1h", "60m", 0},
+		{"1d", "23h", 1},
+		{"1mo", "27d", 1},
+		{"1mo", "32d", -1},
+		{"1y", "11mo27d", 1},
+	} {
+		if got, err := c.a.Compare(c.b); err != nil || got != c.want {
+			t.Errorf("%s compared to %s: expected %d, got %d, %v", c.a, c.b, c.want, got, err)
+		}
+	}
+	if _, err := Duration("1mo").Compare("30d"); err == nil {
+		t.Error("expected 1mo and 30d not to be comparable")
+	}
+
+	start := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	if n, err := Duration("1mo").Windows(start, start.AddDate(1, 0, 0)); err != nil || n != 12 {
+		t.Errorf("expected 12 windows, got %d, %v", n, err)
+	}
+	if n, err := Duration("1h").Windows(start, start.Add(90*time.Minute)); err != nil || n != 2 {
+		t.Errorf("expected 2 windows, got %d, %v", n, err)
+	}
+
+	var w struct{ Every Duration }
+	if err := json.Unmarshal([]byte(`{"Every": 300000000000}`), &w); err != nil || w.Every != "5m" {
+		t.Errorf("expected 5m, got %s, %v", w.Every, err)
+	}
+	var a AggregatorPipe
+	if err := decode(map[string]interface{}{"every": 2 * time.Hour, "fn": "mean"}, &a); err != nil || a.Every != "2h" {
+		t.Errorf("expected 2h, got %s, %v", a.Every, err)
+	}
+}
@@ -0,0 +1,128 @@
+package transformpipe
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+)
+
+var functionType = reflect.TypeOf(&expression.Function{})
+
+// Input is the reverse of TransformInput.Transform: it returns the fn name
+// and the params that decode back into an equivalent pipe. Params are keyed
+// by the field names in lower camel case, e.g. timeColumn, and expressions
+// are stored as their Flux source.
+func Input(t TransformPipe) (*TransformInput, error) {
+	r, ok := lookupType(t)
+	if !ok {
+		return nil, fmt.Errorf("unregistered transform type: %T", t)
+	}
+	v := reflect.ValueOf(t)
+	if v.IsNil() {
+		return nil, fmt.Errorf("%s: nil transform", r.Name)
+	}
+	var params map[string]interface{}
+	var err error
+	if r.Encode != nil {
+		params, err = r.Encode(t)
+	} else {
+		params, err = inputParams(v.Elem())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.Name, err)
+	}
+	input := &TransformInput{Fn: r.Name}
+	if len(params) > 0 {
+		input.Params = params
+	}
+	return input, nil
+}
+
+func inputParams(v reflect.Value) (map[string]interface{}, error) {
+	params := map[string]interface{}{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		key := strings.ToLower(field.Name[:1]) + field.Name[1:]
+		f := v.Field(i)
+		if field.Type == functionType {
+			// a typed lambda is stored as the fn source it renders to
+			if f.IsNil() {
+				continue
+			}
+			fn, err := f.Interface().(*expression.Function).Expr()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			params["fn"] = fn
+			continue
+		}
+		if key == "fn" && f.Kind() == reflect.String && f.String() == "" {
+			continue
+		}
+		value, ok, err := inputValue(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if ok {
+			if _, set := params[key]; !set {
+				params[key] = value
+			}
+		}
+	}
+	return params, nil
+}
+
+// inputValue converts a field into plain Go values. It reports false for
+// nil and empty values, which are left out of the params.
+func inputValue(v reflect.Value) (interface{}, bool, error) {
+	if v.Type() == exprType {
+		if v.IsNil() {
+			return nil, false, nil
+		}
+		s, err := v.Interface().(expression.Expr).Expr()
+		return s, err == nil, err
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, false, nil
+		}
+		return inputValue(v.Elem())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return nil, false, nil
+		}
+		values := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e, _, err := inputValue(v.Index(i))
+			if err != nil {
+				return nil, false, err
+			}
+			values = append(values, e)
+		}
+		return values, true, nil
+	case reflect.Struct:
+		if _, ok := v.Interface().(fmt.Stringer); ok {
+			// time.Time and friends are kept as they are
+			return v.Interface(), true, nil
+		}
+		params, err := inputParams(v)
+		return params, err == nil, err
+	case reflect.String:
+		return v.String(), true, nil
+	case reflect.Bool:
+		return v.Bool(), true, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), true, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true, nil
+	}
+	return nil, false, fmt.Errorf("unsupported param type: %s", v.Type())
+}
@@ -0,0 +1,229 @@
+package transformpipe
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSONSchema is the subset of JSON Schema used to describe the params of the
+// registered transforms, so forms can be rendered and input checked before
+// TransformInput.Transform is called.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Const                string                 `json:"const,omitempty"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *float64               `json:"minimum,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	AnyOf                []*JSONSchema          `json:"anyOf,omitempty"`
+	OneOf                []*JSONSchema          `json:"oneOf,omitempty"`
+	// Order lists the properties in the order of the struct fields, which
+	// is the order forms should show them in.
+	Order []string `json:"x-order,omitempty"`
+}
+
+// Enum is implemented by string types with a fixed set of values, such as
+// TransformFn. The values become the enum of the schema.
+type Enum interface {
+	Values() []string
+}
+
+const durationPattern = `^-?(\d+(ns|us|µs|ms|s|mo|m|h|d|w|y))+$`
+
+var (
+	durationType = reflect.TypeOf(Duration(""))
+	timeType     = reflect.TypeOf(time.Time{})
+	enumType     = reflect.TypeOf((*Enum)(nil)).Elem()
+)
+
+// paramDescriptions describes the params shared by many transforms. The
+// Params of a Registration take precedence.
+var paramDescriptions = map[string]string{
+	"column":         "Column to operate on. Defaults to _value.",
+	"columns":        "Columns to operate on.",
+	"columnKey":      "Columns whose values become the new column names.",
+	"columnName":     "Column to store the result in.",
+	"compression":    "Number of centroids to use when compressing the dataset.",
+	"countColumn":    "Column to store the state count in.",
+	"createEmpty":    "Create empty tables for windows without data.",
+	"desc":           "Sort in descending order.",
+	"duration":       "Amount of time to shift the time columns by.",
+	"durationColumn": "Column to store the state duration in.",
+	"durationUnit":   "Unit of time to report the state duration in.",
+	"every":          "Duration of time between windows.",
+	"fields":         "Columns to set and the Flux expressions of their values.",
+	"fn":             "Flux predicate function, e.g. (r) => r._value > 80.",
+	"initialZero":    "Use zero as the initial value for the first difference.",
+	"interpolate":    "Interpolation method, empty or linear.",
+	"keepFirst":      "Keep the first row of each table.",
+	"location":       "Location used to compute window boundaries.",
+	"method":         "Computation method.",
+	"mode":           "Grouping or calculation mode.",
+	"n":              "Number of records or points.",
+	"name":           "Name of the result.",
+	"nonNegative":    "Drop negative results.",
+	"offset":         "Offset applied to the window boundaries or rows.",
+	"period":         "Duration of each window. Defaults to every.",
+	"q":              "Quantile to compute, between 0 and 1.",
+	"replace":        "Build new records from fields instead of extending the input records.",
+	"rowKey":         "Columns to use as the unique row key.",
+	"startColumn":    "Column containing the window start time.",
+	"stopColumn":     "Column containing the window stop time.",
+	"timeColumn":     "Column containing time values. Defaults to _time.",
+	"timeDst":        "Column to store the window time in.",
+	"timeSrc":        "Column the window time is taken from.",
+	"unit":           "Unit of time used for the computation.",
+	"usePrevious":    "Replace null values with the previous non-null value.",
+	"value":          "Value to replace null values with.",
+	"valueColumn":    "Column containing the values of the new columns.",
+}
+
+// TransformSchema returns the schema of the params of the transform name.
+func TransformSchema(name string) (*JSONSchema, error) {
+	r, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid transform name: %s", name)
+	}
+	return r.schema()
+}
+
+// InputSchema returns the schema of a TransformInput for every registered
+// transform, one alternative per fn.
+func InputSchema() (*JSONSchema, error) {
+	s := &JSONSchema{
+		Schema: "https://json-schema.org/draft/2020-12/schema",
+		Title:  "TransformInput",
+	}
+	for _, name := range Registered() {
+		params, err := TransformSchema(name)
+		if err != nil {
+			return nil, err
+		}
+		input := &JSONSchema{
+			Title:       name,
+			Description: params.Description,
+			Type:        "object",
+			Properties: map[string]*JSONSchema{
+				"fn":     {Type: "string", Const: name},
+				"params": params,
+			},
+			Required: []string{"fn"},
+		}
+		if len(params.Required) > 0 {
+			input.Required = append(input.Required, "params")
+		}
+		s.OneOf = append(s.OneOf, input)
+	}
+	return s, nil
+}
+
+func (r Registration) schema() (*JSONSchema, error) {
+	t := reflect.TypeOf(r.New()).Elem()
+	s, err := structSchema(t, r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.Name, err)
+	}
+	s.Title = r.Name
+	s.Description = r.Description
+	return s, nil
+}
+
+func structSchema(t reflect.Type, r Registration) (*JSONSchema, error) {
+	closed := false
+	s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, AdditionalProperties: &closed}
+	required := map[string]bool{}
+	for _, name := range r.Required {
+		required[name] = true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Type == functionType {
+			// typed lambdas are written to fn
+			continue
+		}
+		key := strings.ToLower(field.Name[:1]) + field.Name[1:]
+		p, err := typeSchema(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if d, ok := r.Params[key]; ok {
+			p.Description = d
+		} else if d, ok := paramDescriptions[key]; ok {
+			p.Description = d
+		}
+		if values, ok := r.Enums[key]; ok {
+			p.Enum = values
+		}
+		s.Properties[key] = p
+		s.Order = append(s.Order, key)
+		if isRequired(field.Type) {
+			required[key] = true
+		}
+	}
+	for _, key := range s.Order {
+		if required[key] {
+			s.Required = append(s.Required, key)
+		}
+	}
+	return s, nil
+}
+
+// isRequired reports whether a field has no usable zero value, i.e. it is
+// not a pointer, a slice, a flag or an untyped value.
+func isRequired(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Interface, reflect.Bool, reflect.Map:
+		return false
+	}
+	return true
+}
+
+func typeSchema(t reflect.Type) (*JSONSchema, error) {
+	switch {
+	case t == durationType:
+		return &JSONSchema{Type: "string", Pattern: durationPattern}, nil
+	case t == timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}, nil
+	case t == exprType:
+		return &JSONSchema{Type: "string", Format: "flux"}, nil
+	case t.Implements(enumType) && t.Kind() == reflect.String:
+		values := reflect.Zero(t).Interface().(Enum).Values()
+		return &JSONSchema{Type: "string", Enum: values}, nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return &JSONSchema{Type: "string"}, nil
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &JSONSchema{Type: "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &JSONSchema{Type: "integer", Minimum: &zero}, nil
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}, nil
+	case reflect.Slice:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &JSONSchema{Type: "array", Items: items}, nil
+	case reflect.Struct:
+		return structSchema(t, Registration{})
+	case reflect.Interface:
+		// untyped values such as the fill value
+		return &JSONSchema{AnyOf: []*JSONSchema{{Type: "string"}, {Type: "number"}, {Type: "boolean"}}}, nil
+	}
+	return nil, fmt.Errorf("unsupported param type: %s", t)
+}
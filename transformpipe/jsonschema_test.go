@@ -0,0 +1,47 @@
+package transformpipe
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestTransformSchema(t *testing.T) {
+	s, err := TransformSchema("aggregateWindow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Required, []string{"every", "fn"}) {
+		t.Errorf("unexpected required params: %v", s.Required)
+	}
+	every := s.Properties["every"]
+	if re := regexp.MustCompile(every.Pattern); every.Format != "" || !re.MatchString("1h30m") || !re.MatchString("5µs") {
+		t.Errorf("expected a Flux duration pattern without format, got %+v", every)
+	}
+	if fn := s.Properties["fn"]; !reflect.DeepEqual(fn.Enum, Mean.Values()) {
+		t.Errorf("expected the aggregate functions as enum, got %v", fn.Enum)
+	}
+	if p := s.Properties["createEmpty"]; p.Type != "boolean" || p.Description == "" {
+		t.Errorf("unexpected createEmpty schema: %+v", p)
+	}
+
+	s, err = TransformSchema("median")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := s.Properties["method"]; !reflect.DeepEqual(m.Enum, []string{"estimate_tdigest", "exact_mean", "estimate_selector"}) {
+		t.Errorf("unexpected method enum: %v", m.Enum)
+	}
+
+	input, err := InputSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(input.OneOf) != len(Registered()) {
+		t.Errorf("expected one alternative per transform, got %d", len(input.OneOf))
+	}
+	if _, err := json.Marshal(input); err != nil {
+		t.Fatal(err)
+	}
+}
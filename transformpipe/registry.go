@@ -0,0 +1,241 @@
+package transformpipe
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Registration describes a pipe type TransformInput can build.
+type Registration struct {
+	// Name is the fn of the TransformInput, usually the Flux function name.
+	Name string
+	// New returns a pointer to an empty pipe.
+	New func() TransformPipe
+	// Decode fills the pipe from the params of a TransformInput. It defaults
+	// to mapstructure decoding that also accepts Flux source for
+	// expression.Expr fields.
+	Decode func(params map[string]interface{}, output TransformPipe) error
+	// Encode is the reverse of Decode, used by Input. It defaults to the
+	// exported fields keyed in lower camel case.
+	Encode func(t TransformPipe) (map[string]interface{}, error)
+
+	// Description, Params, Required and Enums document the transform in its
+	// JSONSchema. Params maps param names to their descriptions. Fields that
+	// are neither pointers, slices nor flags are required anyway.
+	Description string
+	Params      map[string]string
+	Required    []string
+	Enums       map[string][]string
+}
+
+func (r Registration) decode(params map[string]interface{}, output TransformPipe) error {
+	if r.Decode != nil {
+		return r.Decode(params, output)
+	}
+	return decode(params, output)
+}
+
+var registry = struct {
+	sync.RWMutex
+	byName map[string]Registration
+	byType map[reflect.Type]Registration
+}{
+	byName: map[string]Registration{},
+	byType: map[reflect.Type]Registration{},
+}
+
+// Register adds a pipe type, so that it can be built through TransformInput
+// and stored in query specs. Names and types can only be registered once.
+func Register(r Registration) error {
+	if r.Name == "" {
+		return fmt.Errorf("transform name is required")
+	}
+	if r.New == nil {
+		return fmt.Errorf("transform %s: constructor is required", r.Name)
+	}
+	tp := r.New()
+	t := reflect.TypeOf(tp)
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("transform %s: constructor must return a pointer to a struct, got %T", r.Name, tp)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.byName[r.Name]; ok {
+		return fmt.Errorf("transform %s is already registered", r.Name)
+	}
+	if other, ok := registry.byType[t]; ok {
+		return fmt.Errorf("transform %s: %T is already registered as %s", r.Name, tp, other.Name)
+	}
+	registry.byName[r.Name] = r
+	registry.byType[t] = r
+	return nil
+}
+
+// MustRegister is Register for package initialization. It panics on error.
+func MustRegister(r Registration) {
+	if err := Register(r); err != nil {
+		panic(err)
+	}
+}
+
+// Lookup returns the registration of the transform name.
+func Lookup(name string) (Registration, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	r, ok := registry.byName[name]
+	return r, ok
+}
+
+func lookupType(t TransformPipe) (Registration, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	r, ok := registry.byType[reflect.TypeOf(t)]
+	return r, ok
+}
+
+// NameOf returns the registered name of the pipe, or its Go type when it is
+// not registered.
+func NameOf(t TransformPipe) string {
+	if r, ok := lookupType(t); ok {
+		return r.Name
+	}
+	return fmt.Sprintf("%T", t)
+}
+
+// Registered returns the names of all registered transforms, sorted.
+func Registered() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.byName))
+	for name := range registry.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	for _, r := range []Registration{
+		{Name: "aggregateWindow", New: func() TransformPipe { return &AggregatorPipe{} },
+			Description: "Downsamples data by grouping it into fixed windows of time and applying an aggregate function to each window.", Params: map[string]string{"fn": "Aggregate function applied to each window."}},
+		{Name: "bottom", New: func() TransformPipe { return &BottomPipe{} },
+			Description: "Sorts each input table by columns and keeps the bottom n records."},
+		{Name: "top", New: func() TransformPipe { return &TopPipe{} },
+			Description: "Sorts each input table by columns and keeps the top n records."},
+		{Name: "count", New: func() TransformPipe { return &CountPipe{} },
+			Description: "Returns the number of non-null values in a column."},
+		{Name: "cumulativeSum", New: func() TransformPipe { return &CumulativeSumPipe{} },
+			Description: "Computes a running sum of non-null values."},
+		{Name: "derivative", New: func() TransformPipe { return &DerivativePipe{} },
+			Description: "Computes the rate of change per unit of time between subsequent non-null records."},
+		{Name: "difference", New: func() TransformPipe { return &DifferencePipe{} },
+			Description: "Returns the difference between subsequent values."},
+		{Name: "distinct", New: func() TransformPipe { return &DistinctPipe{} },
+			Description: "Returns all unique values in a column."},
+		{Name: "doubleEMA", New: func() TransformPipe { return &DoubleEMAPipe{} },
+			Description: "Returns the double exponential moving average of values in the _value column."},
+		{Name: "elapsed", New: func() TransformPipe { return &ElapsedPipe{} },
+			Description: "Returns the time between subsequent records."},
+		{Name: "exponentialMovingAverage", New: func() TransformPipe { return &ExponentialMovingAveragePipe{} },
+			Description: "Calculates the exponential moving average of values in the _value column."},
+		{Name: "fill", New: func() TransformPipe { return &FillPipe{} },
+			Description: "Replaces all null values in a column with a non-null value.", Params: map[string]string{"column": "Column to replace null values in. Defaults to _value."}},
+		{Name: "filter", New: func() TransformPipe { return &FilterPipe{} },
+			Description: "Filters data based on a predicate function."},
+		{Name: "fieldsAsCols", New: func() TransformPipe { return &FieldsAsColsPipe{} },
+			Description: "Pivots fields into columns, one row per timestamp."},
+		{Name: "first", New: func() TransformPipe { return &FirstPipe{} },
+			Description: "Returns the first non-null record of each table."},
+		{Name: "group", New: func() TransformPipe { return &GroupPipe{} },
+			Description: "Regroups input data by modifying the group key of input tables.", Enums: map[string][]string{"mode": {"by", "except"}}},
+		{Name: "last", New: func() TransformPipe { return &LastPipe{} },
+			Description: "Returns the last non-null record of each table."},
+		{Name: "increase", New: func() TransformPipe { return &IncreasePipe{} },
+			Description: "Returns the cumulative sum of non-negative differences between subsequent values."},
+		{Name: "integral", New: func() TransformPipe { return &IntegralPipe{} },
+			Description: "Computes the area under the curve per unit of time of subsequent non-null records.", Enums: map[string][]string{"interpolate": {"", "linear"}}},
+		{Name: "kaufmansAMA", New: func() TransformPipe { return &KaufmansAMAPipe{} },
+			Description: "Calculates the Kaufman's Adaptive Moving Average of values in a column."},
+		{Name: "kaufmansER", New: func() TransformPipe { return &KaufmansERPipe{} },
+			Description: "Computes the Kaufman's Efficiency Ratio of values in the _value column."},
+		{Name: "limit", New: func() TransformPipe { return &LimitPipe{} },
+			Description: "Returns the first n rows after the specified offset from each input table."},
+		{Name: "map", New: func() TransformPipe { return &MapPipe{} },
+			Description: "Sets columns of each record from Flux expressions.", Required: []string{"fields"}},
+		{Name: "max", New: func() TransformPipe { return &MaxPipe{} },
+			Description: "Returns the row with the maximum value of a column."},
+		{Name: "mean", New: func() TransformPipe { return &MeanPipe{} },
+			Description: "Returns the average of non-null values of a column."},
+		{Name: "median", New: func() TransformPipe { return &MedianPipe{} },
+			Description: "Returns the median value of a column."},
+		{Name: "min", New: func() TransformPipe { return &MinPipe{} },
+			Description: "Returns the row with the minimum value of a column."},
+		{Name: "mode", New: func() TransformPipe { return &ModePipe{} },
+			Description: "Returns the non-null value that occurs most often in a column."},
+		{Name: "movingAverage", New: func() TransformPipe { return &MovingAveragePipe{} },
+			Description: "Calculates the mean of non-null values using the current value and n - 1 previous values."},
+		{Name: "pivot", New: func() TransformPipe { return &PivotPipe{} },
+			Description: "Collects unique values of the column key into new columns.", Required: []string{"rowKey", "columnKey"}},
+		{Name: "quantile", New: func() TransformPipe { return &QuantilePipe{} },
+			Description: "Returns rows containing values that fall within a specified quantile."},
+		{Name: "relativeStrengthIndex", New: func() TransformPipe { return &RelativeStrengthIndexPipe{} },
+			Description: "Measures the relative speed and change of values in a table."},
+		{Name: "skew", New: func() TransformPipe { return &SkewPipe{} },
+			Description: "Returns the skew of non-null records."},
+		{Name: "spread", New: func() TransformPipe { return &SpreadPipe{} },
+			Description: "Returns the difference between the minimum and maximum values of a column."},
+		{Name: "sort", New: func() TransformPipe { return &SortPipe{} },
+			Description: "Orders rows by values of the columns."},
+		{Name: "stddev", New: func() TransformPipe { return &StddevPipe{} },
+			Description: "Returns the standard deviation of non-null values of a column."},
+		{Name: "stateCount", New: func() TransformPipe { return &StateCountPipe{} },
+			Description: "Returns the number of consecutive rows in a given state."},
+		{Name: "stateTracking", New: func() TransformPipe { return &StateTrackingPipe{} },
+			Description: "Returns the cumulative count and duration of consecutive rows that match a predicate."},
+		{Name: "sum", New: func() TransformPipe { return &SumPipe{} },
+			Description: "Returns the sum of non-null values of a column."},
+		{Name: "timeShift", New: func() TransformPipe { return &TimeShiftPipe{} },
+			Description: "Adds a fixed duration to time columns."},
+		{Name: "stateDuration", New: func() TransformPipe { return &StateDurationPipe{} },
+			Description: "Returns the cumulative duration of a given state."},
+		{Name: "tail", New: func() TransformPipe { return &TailPipe{} },
+			Description: "Limits each output table to the last n rows."},
+		{Name: "timeMovingAverage", New: func() TransformPipe { return &TimeMovingAveragePipe{} },
+			Description: "Returns the average of the current value and all row values in the previous period."},
+		{Name: "timeWeightedAvg", New: func() TransformPipe { return &TimeWeightedAvgPipe{} },
+			Description: "Returns the time-weighted average of non-null values in the _value column."},
+		{Name: "keep", New: func() TransformPipe { return &KeepPipe{} },
+			Description: "Returns a stream of tables containing only the specified columns.", Required: []string{"columns"}},
+		{Name: "drop", New: func() TransformPipe { return &DropPipe{} },
+			Description: "Removes the specified columns from a table.", Required: []string{"columns"}},
+		{Name: "toBool", New: func() TransformPipe { return &ToBoolPipe{} },
+			Description: "Converts all values in the _value column to booleans."},
+		{Name: "toFloat", New: func() TransformPipe { return &ToFloatPipe{} },
+			Description: "Converts all values in the _value column to floats."},
+		{Name: "toInt", New: func() TransformPipe { return &ToIntPipe{} },
+			Description: "Converts all values in the _value column to integers."},
+		{Name: "toString", New: func() TransformPipe { return &ToStringPipe{} },
+			Description: "Converts all values in the _value column to strings."},
+		{Name: "toTime", New: func() TransformPipe { return &ToTimePipe{} },
+			Description: "Converts all values in the _value column to times."},
+		{Name: "toUInt", New: func() TransformPipe { return &ToUIntPipe{} },
+			Description: "Converts all values in the _value column to unsigned integers."},
+		{Name: "tripleEMA", New: func() TransformPipe { return &TripleEMAPipe{} },
+			Description: "Returns the triple exponential moving average of values in the _value column."},
+		{Name: "tripleExponentialDerivative", New: func() TransformPipe { return &TripleExponentialDerivativePipe{} },
+			Description: "Returns the triple exponential derivative oscillator of values in the _value column."},
+		{Name: "truncateTimeColumn", New: func() TransformPipe { return &TruncateTimeColumnPipe{} },
+			Description: "Truncates all _time values to a specified unit."},
+		{Name: "unique", New: func() TransformPipe { return &UniquePipe{} },
+			Description: "Returns all records containing unique values in a column."},
+		{Name: "window", New: func() TransformPipe { return &WindowPipe{} },
+			Description: "Groups records based on time values into windows."},
+		{Name: "yield", New: func() TransformPipe { return &YieldPipe{} },
+			Description: "Delivers the result of the query under a name."},
+	} {
+		MustRegister(r)
+	}
+}
@@ -0,0 +1,13 @@
+package transformpipe
+
+// FieldsAsColsPipe pivots _field into columns keyed by _time, the shortcut
+// for pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value").
+type FieldsAsColsPipe struct{}
+
+func (a *FieldsAsColsPipe) Pipe() (string, error) {
+	return "|> schema.fieldsAsCols()", nil
+}
+
+func (a *FieldsAsColsPipe) Imports() []string {
+	return []string{"influxdata/influxdb/schema"}
+}
@@ -3,18 +3,43 @@ package transformpipe
 import (
 	"fmt"
 	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/expression"
+	"github.com/ThinkontrolSY/flux-builder/literal"
 )
 
+// fnParam renders a fn: argument, preferring the typed lambda over the raw
+// Flux string.
+func fnParam(fn string, lambda *expression.Function) (string, error) {
+	if lambda != nil {
+		return lambda.Expr()
+	}
+	if fn == "" {
+		return "", fmt.Errorf("fn is required")
+	}
+	return fn, nil
+}
+
+func lambdaImports(fn string, lambda *expression.Function) []string {
+	if lambda == nil {
+		return expression.Raw(fn).Imports()
+	}
+	return expression.Imports(lambda)
+}
+
 type BottomPipe struct {
 	N       int
 	Columns []string
 }
 
 func (a *BottomPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	params = append(params, fmt.Sprintf("n: %d", a.N))
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 
 	return fmt.Sprintf("|> bottom(%s)", strings.Join(params, ", ")), nil
@@ -26,10 +51,13 @@ type TopPipe struct {
 }
 
 func (a *TopPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	params = append(params, fmt.Sprintf("n: %d", a.N))
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 
 	return fmt.Sprintf("|> top(%s)", strings.Join(params, ", ")), nil
@@ -41,7 +69,7 @@ type CountPipe struct {
 
 func (a *CountPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> count(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> count(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> count()", nil
 }
@@ -52,7 +80,7 @@ type CumulativeSumPipe struct {
 
 func (a *CumulativeSumPipe) Pipe() (string, error) {
 	if len(a.Columns) > 0 {
-		return fmt.Sprintf(`|> cumulativeSum(columns: ["%s"])`, strings.Join(a.Columns, `", "`)), nil
+		return fmt.Sprintf("|> cumulativeSum(columns: %s)", literal.Strings(a.Columns)), nil
 	}
 
 	return "|> cumulativeSum()", nil
@@ -66,19 +94,18 @@ type DerivativePipe struct {
 }
 
 func (a *DerivativePipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 	if a.TimeColumn != nil {
-		params = append(params, fmt.Sprintf(`timeColumn: "%s"`, *a.TimeColumn))
+		params = append(params, fmt.Sprintf("timeColumn: %s", literal.String(*a.TimeColumn)))
 	}
 	if a.Unit != nil {
-		if err := a.Unit.Error(); err != nil {
-			return "", err
-		} else {
-			params = append(params, fmt.Sprintf("unit: %s", *a.Unit))
-		}
+		params = append(params, fmt.Sprintf("unit: %s", *a.Unit))
 	}
 	if a.NonNegative != nil {
 		params = append(params, fmt.Sprintf("nonNegative: %t", *a.NonNegative))
@@ -98,7 +125,7 @@ type DifferencePipe struct {
 func (a *DifferencePipe) Pipe() (string, error) {
 	var params []string
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 	if a.KeepFirst != nil {
 		params = append(params, fmt.Sprintf("keepFirst: %t", *a.KeepFirst))
@@ -120,7 +147,7 @@ type DistinctPipe struct {
 
 func (a *DistinctPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> distinct(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> distinct(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> distinct()", nil
 }
@@ -130,8 +157,8 @@ type DoubleEMAPipe struct {
 }
 
 func (a *DoubleEMAPipe) Pipe() (string, error) {
-	if a.N <= 0 {
-		return "", fmt.Errorf("n must be greater than 0")
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("|> doubleEMA(n: %d)", a.N), nil
 }
@@ -143,19 +170,18 @@ type ElapsedPipe struct {
 }
 
 func (a *ElapsedPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.TimeColumn != nil {
-		params = append(params, fmt.Sprintf(`timeColumn: "%s"`, *a.TimeColumn))
+		params = append(params, fmt.Sprintf("timeColumn: %s", literal.String(*a.TimeColumn)))
 	}
 	if a.Unit != nil {
-		if err := a.Unit.Error(); err != nil {
-			return "", err
-		} else {
-			params = append(params, fmt.Sprintf("unit: %s", *a.Unit))
-		}
+		params = append(params, fmt.Sprintf("unit: %s", *a.Unit))
 	}
 	if a.ColumnName != nil {
-		params = append(params, fmt.Sprintf(`columnName: "%s"`, *a.ColumnName))
+		params = append(params, fmt.Sprintf("columnName: %s", literal.String(*a.ColumnName)))
 	}
 
 	return fmt.Sprintf("|> elapsed(%s)", strings.Join(params, ", ")), nil
@@ -167,15 +193,30 @@ type ExponentialMovingAveragePipe struct {
 }
 
 func (a *ExponentialMovingAveragePipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	return fmt.Sprintf("|> exponentialMovingAverage(n: %d)", a.N), nil
 }
 
 type FilterPipe struct {
-	Fn string
+	Fn     string
+	Lambda *expression.Function
 }
 
 func (a *FilterPipe) Pipe() (string, error) {
-	return fmt.Sprintf("|> filter(fn: %s)", a.Fn), nil
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	fn, err := fnParam(a.Fn, a.Lambda)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("|> filter(fn: %s)", fn), nil
+}
+
+func (a *FilterPipe) Imports() []string {
+	return lambdaImports(a.Fn, a.Lambda)
 }
 
 type FillPipe struct {
@@ -185,29 +226,21 @@ type FillPipe struct {
 }
 
 func (a *FillPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.UsePrevious != nil && *a.UsePrevious == true {
 		params = append(params, "usePrevious: true")
-	} else if a.Value != nil {
-		switch a.Value.(type) {
-		case string:
-			params = append(params, fmt.Sprintf(`value: "%s"`, a.Value.(string)))
-		case int:
-			params = append(params, fmt.Sprintf(`value: %d`, a.Value.(int)))
-		case float64:
-			params = append(params, fmt.Sprintf(`value: %f`, a.Value.(float64)))
-		case bool:
-			params = append(params, fmt.Sprintf(`value: %t`, a.Value.(bool)))
-		default:
-			return "", fmt.Errorf("unsupported value type: %T", a.Value)
+	} else {
+		v, err := literal.Value(a.Value)
+		if err != nil {
+			return "", err
 		}
-	}
-
-	if len(params) == 0 {
-		return "", fmt.Errorf("fill requires at least one parameter")
+		params = append(params, fmt.Sprintf("value: %s", v))
 	}
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> fill(%s)", strings.Join(params, ", ")), nil
 
@@ -225,16 +258,15 @@ type GroupPipe struct {
 }
 
 func (a *GroupPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.Mode != nil {
-		mode := "by"
-		if *a.Mode == "except" {
-			mode = "except"
-		}
-		params = append(params, fmt.Sprintf(`mode: "%s"`, mode))
+		params = append(params, fmt.Sprintf("mode: %s", literal.String(*a.Mode)))
 	}
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 	return fmt.Sprintf("|> group(%s)", strings.Join(params, ", ")), nil
 }
@@ -251,7 +283,7 @@ type IncreasePipe struct {
 
 func (a *IncreasePipe) Pipe() (string, error) {
 	if len(a.Columns) > 0 {
-		return fmt.Sprintf(`|> increase(columns: ["%s"])`, strings.Join(a.Columns, `", "`)), nil
+		return fmt.Sprintf("|> increase(columns: %s)", literal.Strings(a.Columns)), nil
 	}
 
 	return "|> increase()", nil
@@ -265,20 +297,19 @@ type IntegralPipe struct {
 }
 
 func (a *IntegralPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.TimeColumn != nil {
-		params = append(params, fmt.Sprintf(`timeColumn: "%s"`, *a.TimeColumn))
-	}
-	if err := a.Unit.Error(); err != nil {
-		return "", err
-	} else {
-		params = append(params, fmt.Sprintf("unit: %s", a.Unit))
+		params = append(params, fmt.Sprintf("timeColumn: %s", literal.String(*a.TimeColumn)))
 	}
+	params = append(params, fmt.Sprintf("unit: %s", a.Unit))
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	if a.Interpolate != nil {
-		params = append(params, fmt.Sprintf(`interpolate: "%s"`, *a.Interpolate))
+		params = append(params, fmt.Sprintf("interpolate: %s", literal.String(*a.Interpolate)))
 	}
 
 	return fmt.Sprintf("|> integral(%s)", strings.Join(params, ", ")), nil
@@ -290,10 +321,13 @@ type KaufmansAMAPipe struct {
 }
 
 func (a *KaufmansAMAPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	params = append(params, fmt.Sprintf("n: %d", a.N))
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> kaufmansAMA(%s)", strings.Join(params, ", ")), nil
 }
@@ -303,6 +337,9 @@ type KaufmansERPipe struct {
 }
 
 func (a *KaufmansERPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	return fmt.Sprintf("|> kaufmansER(n: %d)", a.N), nil
 }
 
@@ -312,6 +349,9 @@ type LimitPipe struct {
 }
 
 func (a *LimitPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	params = append(params, fmt.Sprintf("n: %d", a.N))
 	if a.Offset != nil {
@@ -320,13 +360,51 @@ func (a *LimitPipe) Pipe() (string, error) {
 	return fmt.Sprintf("|> limit(%s)", strings.Join(params, ", ")), nil
 }
 
+type MapField struct {
+	Column string
+	Value  expression.Expr
+}
+
+type MapPipe struct {
+	Fields []MapField
+	// Replace builds a new record from Fields instead of extending r with them.
+	Replace bool
+}
+
+func (a *MapPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	props := make([]expression.Property, 0, len(a.Fields))
+	for _, f := range a.Fields {
+		props = append(props, expression.Prop(f.Column, f.Value))
+	}
+	record := expression.With(expression.Ident("r"), props...)
+	if a.Replace {
+		record = expression.Record(props...)
+	}
+	fn, err := expression.Fn(record).Expr()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("|> map(fn: %s)", fn), nil
+}
+
+func (a *MapPipe) Imports() []string {
+	props := make([]expression.Property, 0, len(a.Fields))
+	for _, f := range a.Fields {
+		props = append(props, expression.Prop(f.Column, f.Value))
+	}
+	return expression.Imports(expression.Record(props...))
+}
+
 type MaxPipe struct {
 	Column *string
 }
 
 func (a *MaxPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> max(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> max(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> max()", nil
 }
@@ -337,7 +415,7 @@ type MinPipe struct {
 
 func (a *MinPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> min(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> min(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> min()", nil
 }
@@ -348,7 +426,7 @@ type ModePipe struct {
 
 func (a *ModePipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> mode(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> mode(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> mode()", nil
 }
@@ -359,7 +437,7 @@ type MeanPipe struct {
 
 func (a *MeanPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> mean(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> mean(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> mean()", nil
 }
@@ -372,6 +450,10 @@ const (
 	EstimateSelector Estimate = "estimate_selector"
 )
 
+func (Estimate) Values() []string {
+	return []string{string(EstimateTdigest), string(EstimateMean), string(EstimateSelector)}
+}
+
 type MedianPipe struct {
 	Column      *string
 	Method      *Estimate
@@ -379,15 +461,18 @@ type MedianPipe struct {
 }
 
 func (a *MedianPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.Method != nil {
-		params = append(params, fmt.Sprintf(`method: "%s"`, *a.Method))
+		params = append(params, fmt.Sprintf("method: %s", literal.String(string(*a.Method))))
 	}
 	if a.Compression != nil {
 		params = append(params, fmt.Sprintf(`compression: %f`, *a.Compression))
 	}
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> median(%s)", strings.Join(params, ", ")), nil
 }
@@ -397,12 +482,29 @@ type MovingAveragePipe struct {
 }
 
 func (a *MovingAveragePipe) Pipe() (string, error) {
-	if a.N <= 0 {
-		return "", fmt.Errorf("n must be greater than 0")
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("|> movingAverage(n: %d)", a.N), nil
 }
 
+type PivotPipe struct {
+	RowKey      []string
+	ColumnKey   []string
+	ValueColumn string
+}
+
+func (a *PivotPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	var params []string
+	params = append(params, fmt.Sprintf("rowKey: %s", literal.Strings(a.RowKey)))
+	params = append(params, fmt.Sprintf("columnKey: %s", literal.Strings(a.ColumnKey)))
+	params = append(params, fmt.Sprintf("valueColumn: %s", literal.String(a.ValueColumn)))
+	return fmt.Sprintf("|> pivot(%s)", strings.Join(params, ", ")), nil
+}
+
 type QuantilePipe struct {
 	Q           float64
 	Column      *string
@@ -411,16 +513,19 @@ type QuantilePipe struct {
 }
 
 func (a *QuantilePipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	params = append(params, fmt.Sprintf("q: %f", a.Q))
 	if a.Method != nil {
-		params = append(params, fmt.Sprintf(`method: "%s"`, *a.Method))
+		params = append(params, fmt.Sprintf("method: %s", literal.String(string(*a.Method))))
 	}
 	if a.Compression != nil {
 		params = append(params, fmt.Sprintf(`compression: %f`, *a.Compression))
 	}
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> quantile(%s)", strings.Join(params, ", ")), nil
 }
@@ -431,13 +536,13 @@ type RelativeStrengthIndexPipe struct {
 }
 
 func (a *RelativeStrengthIndexPipe) Pipe() (string, error) {
-	var params []string
-	if a.N <= 0 {
-		return "", fmt.Errorf("n must be greater than 0")
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
+	var params []string
 	params = append(params, fmt.Sprintf("n: %d", a.N))
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 	return fmt.Sprintf("|> relativeStrengthIndex(%s)", strings.Join(params, ", ")), nil
 }
@@ -448,7 +553,7 @@ type SkewPipe struct {
 
 func (a *SkewPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> skew(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> skew(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> skew()", nil
 }
@@ -461,7 +566,7 @@ type SortPipe struct {
 func (a *SortPipe) Pipe() (string, error) {
 	var params []string
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 	if a.Desc != nil {
 		params = append(params, fmt.Sprintf(`desc: %t`, *a.Desc))
@@ -475,7 +580,7 @@ type SpreadPipe struct {
 
 func (a *SpreadPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> spread(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> spread(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> spread()", nil
 }
@@ -483,83 +588,118 @@ func (a *SpreadPipe) Pipe() (string, error) {
 type StateCountPipe struct {
 	Column *string
 	Fn     string
+	Lambda *expression.Function
 }
 
 func (a *StateCountPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	fn, err := fnParam(a.Fn, a.Lambda)
+	if err != nil {
+		return "", err
+	}
 	var params []string
-	params = append(params, fmt.Sprintf(`fn: %s`, a.Fn))
+	params = append(params, fmt.Sprintf(`fn: %s`, fn))
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> stateCount(%s)", strings.Join(params, ", ")), nil
 }
 
+func (a *StateCountPipe) Imports() []string {
+	return lambdaImports(a.Fn, a.Lambda)
+}
+
 type StateDurationPipe struct {
 	Column *string
 	Fn     string
+	Lambda *expression.Function
 	Unit   *Duration
 }
 
 func (a *StateDurationPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	fn, err := fnParam(a.Fn, a.Lambda)
+	if err != nil {
+		return "", err
+	}
 	var params []string
-	params = append(params, fmt.Sprintf(`fn: %s`, a.Fn))
+	params = append(params, fmt.Sprintf(`fn: %s`, fn))
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	if a.Unit != nil {
-		if err := a.Unit.Error(); err != nil {
-			return "", err
-		} else {
-			params = append(params, fmt.Sprintf("unit: %s", *a.Unit))
-		}
+		params = append(params, fmt.Sprintf("unit: %s", *a.Unit))
 	}
 	return fmt.Sprintf("|> stateDuration(%s)", strings.Join(params, ", ")), nil
 }
 
+func (a *StateDurationPipe) Imports() []string {
+	return lambdaImports(a.Fn, a.Lambda)
+}
+
 type StateTrackingPipe struct {
 	CountColumn    *string
 	DurationColumn *string
 	Fn             string
+	Lambda         *expression.Function
 	DurationUnit   *Duration
 }
 
 func (a *StateTrackingPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
+	fn, err := fnParam(a.Fn, a.Lambda)
+	if err != nil {
+		return "", err
+	}
 	var params []string
-	params = append(params, fmt.Sprintf(`fn: %s`, a.Fn))
+	params = append(params, fmt.Sprintf(`fn: %s`, fn))
 	if a.CountColumn != nil {
-		params = append(params, fmt.Sprintf(`countColumn: "%s"`, *a.CountColumn))
+		params = append(params, fmt.Sprintf("countColumn: %s", literal.String(*a.CountColumn)))
 	}
 	if a.DurationColumn != nil {
-		params = append(params, fmt.Sprintf(`durationColumn: "%s"`, *a.DurationColumn))
+		params = append(params, fmt.Sprintf("durationColumn: %s", literal.String(*a.DurationColumn)))
 	}
 	if a.DurationUnit != nil {
-		if err := a.DurationUnit.Error(); err != nil {
-			return "", err
-		} else {
-			params = append(params, fmt.Sprintf("durationUnit: %s", *a.DurationUnit))
-		}
+		params = append(params, fmt.Sprintf("durationUnit: %s", *a.DurationUnit))
 	}
 	return fmt.Sprintf("|> stateTracking(%s)", strings.Join(params, ", ")), nil
 }
 
+func (a *StateTrackingPipe) Imports() []string {
+	return lambdaImports(a.Fn, a.Lambda)
+}
+
 type StddevMode string // "population" or "sample"
 const (
 	StddevModePopulation StddevMode = "population"
 	StddevModeSample     StddevMode = "sample"
 )
 
+func (StddevMode) Values() []string {
+	return []string{string(StddevModePopulation), string(StddevModeSample)}
+}
+
 type StddevPipe struct {
 	Column *string
 	Mode   *StddevMode
 }
 
 func (a *StddevPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.Mode != nil {
-		params = append(params, fmt.Sprintf(`mode: "%s"`, *a.Mode))
+		params = append(params, fmt.Sprintf("mode: %s", literal.String(string(*a.Mode))))
 	}
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> stddev(%s)", strings.Join(params, ", ")), nil
 }
@@ -570,7 +710,7 @@ type SumPipe struct {
 
 func (a *SumPipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> sum(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> sum(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> sum()", nil
 }
@@ -581,8 +721,8 @@ type TailPipe struct {
 }
 
 func (a *TailPipe) Pipe() (string, error) {
-	if a.N <= 0 {
-		return "", fmt.Errorf("n must be greater than 0")
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
 	var params []string
 	params = append(params, fmt.Sprintf("n: %d", a.N))
@@ -599,16 +739,14 @@ type TimeMovingAveragePipe struct {
 }
 
 func (a *TimeMovingAveragePipe) Pipe() (string, error) {
-	if err := a.Every.Error(); err != nil {
-		return "", err
-	} else if err := a.Period.Error(); err != nil {
+	if err := a.Validate(); err != nil {
 		return "", err
 	}
 	var params []string
 	params = append(params, fmt.Sprintf("every: %s", a.Every))
 	params = append(params, fmt.Sprintf("period: %s", a.Period))
 	if a.Column != nil {
-		params = append(params, fmt.Sprintf(`column: "%s"`, *a.Column))
+		params = append(params, fmt.Sprintf("column: %s", literal.String(*a.Column)))
 	}
 	return fmt.Sprintf("|> timeMovingAverage(%s)", strings.Join(params, ", ")), nil
 }
@@ -619,13 +757,13 @@ type TimeShiftPipe struct {
 }
 
 func (a *TimeShiftPipe) Pipe() (string, error) {
-	if err := a.Duration.Error(); err != nil {
+	if err := a.Validate(); err != nil {
 		return "", err
 	}
 	var params []string
 	params = append(params, fmt.Sprintf("duration: %s", a.Duration))
 	if len(a.Columns) > 0 {
-		params = append(params, fmt.Sprintf(`columns: ["%s"]`, strings.Join(a.Columns, `", "`)))
+		params = append(params, fmt.Sprintf("columns: %s", literal.Strings(a.Columns)))
 	}
 	return fmt.Sprintf("|> timeShift(%s)", strings.Join(params, ", ")), nil
 }
@@ -635,10 +773,10 @@ type KeepPipe struct {
 }
 
 func (a *KeepPipe) Pipe() (string, error) {
-	if len(a.Columns) > 0 {
-		return fmt.Sprintf(`|> keep(columns: ["%s"])`, strings.Join(a.Columns, `", "`)), nil
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("keep requires at least one column")
+	return fmt.Sprintf("|> keep(columns: %s)", literal.Strings(a.Columns)), nil
 }
 
 type DropPipe struct {
@@ -646,10 +784,10 @@ type DropPipe struct {
 }
 
 func (a *DropPipe) Pipe() (string, error) {
-	if len(a.Columns) > 0 {
-		return fmt.Sprintf(`|> drop(columns: ["%s"])`, strings.Join(a.Columns, `", "`)), nil
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("drop requires at least one column")
+	return fmt.Sprintf("|> drop(columns: %s)", literal.Strings(a.Columns)), nil
 }
 
 type TimeWeightedAvgPipe struct {
@@ -657,7 +795,7 @@ type TimeWeightedAvgPipe struct {
 }
 
 func (a *TimeWeightedAvgPipe) Pipe() (string, error) {
-	if err := a.Unit.Error(); err != nil {
+	if err := a.Validate(); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("|> timeWeightedAvg(unit: %s)", a.Unit), nil
@@ -704,8 +842,8 @@ type TripleEMAPipe struct {
 }
 
 func (a *TripleEMAPipe) Pipe() (string, error) {
-	if a.N <= 0 {
-		return "", fmt.Errorf("n must be greater than 0")
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("|> tripleEMA(n: %d)", a.N), nil
 }
@@ -715,8 +853,8 @@ type TripleExponentialDerivativePipe struct {
 }
 
 func (a *TripleExponentialDerivativePipe) Pipe() (string, error) {
-	if a.N <= 0 {
-		return "", fmt.Errorf("n must be greater than 0")
+	if err := a.Validate(); err != nil {
+		return "", err
 	}
 	return fmt.Sprintf("|> tripleExponentialDerivative(n: %d)", a.N), nil
 }
@@ -726,7 +864,7 @@ type TruncateTimeColumnPipe struct {
 }
 
 func (a *TruncateTimeColumnPipe) Pipe() (string, error) {
-	if err := a.Unit.Error(); err != nil {
+	if err := a.Validate(); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("|> truncateTimeColumn(unit: %s)", a.Unit), nil
@@ -738,7 +876,7 @@ type UniquePipe struct {
 
 func (a *UniquePipe) Pipe() (string, error) {
 	if a.Column != nil {
-		return fmt.Sprintf(`|> unique(column: "%s")`, *a.Column), nil
+		return fmt.Sprintf("|> unique(column: %s)", literal.String(*a.Column)), nil
 	}
 	return "|> unique()", nil
 }
@@ -755,6 +893,9 @@ type WindowPipe struct {
 }
 
 func (a *WindowPipe) Pipe() (string, error) {
+	if err := a.Validate(); err != nil {
+		return "", err
+	}
 	var params []string
 	if a.Every != nil {
 		params = append(params, fmt.Sprintf("every: %s", *a.Every))
@@ -762,23 +903,20 @@ func (a *WindowPipe) Pipe() (string, error) {
 	if a.Period != nil {
 		params = append(params, fmt.Sprintf("period: %s", *a.Period))
 	}
-	if len(params) == 0 {
-		return "", fmt.Errorf("window function requires at least one of \"every\" or \"period\" to be set and non-zero")
-	}
 	if a.Offset != nil {
 		params = append(params, fmt.Sprintf("offset: %s", *a.Offset))
 	}
 	if a.TimeColumn != nil {
-		params = append(params, fmt.Sprintf(`timeColumn: "%s"`, *a.TimeColumn))
+		params = append(params, fmt.Sprintf("timeColumn: %s", literal.String(*a.TimeColumn)))
 	}
 	if a.StartColumn != nil {
-		params = append(params, fmt.Sprintf(`startColumn: "%s"`, *a.StartColumn))
+		params = append(params, fmt.Sprintf("startColumn: %s", literal.String(*a.StartColumn)))
 	}
 	if a.StopColumn != nil {
-		params = append(params, fmt.Sprintf(`stopColumn: "%s"`, *a.StopColumn))
+		params = append(params, fmt.Sprintf("stopColumn: %s", literal.String(*a.StopColumn)))
 	}
 	if a.Location != nil {
-		params = append(params, fmt.Sprintf(`location: "%s"`, *a.Location))
+		params = append(params, fmt.Sprintf("location: %s", literal.String(*a.Location)))
 	}
 	if a.CreateEmpty != nil {
 		params = append(params, fmt.Sprintf(`createEmpty: %t`, *a.CreateEmpty))
@@ -792,7 +930,7 @@ type YieldPipe struct {
 
 func (a *YieldPipe) Pipe() (string, error) {
 	if a.Name != nil {
-		return fmt.Sprintf(`|> yield(name: "%s")`, *a.Name), nil
+		return fmt.Sprintf("|> yield(name: %s)", literal.String(*a.Name)), nil
 	}
 	return "|> yield()", nil
 }
@@ -0,0 +1,404 @@
+package transformpipe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ThinkontrolSY/flux-builder/literal"
+)
+
+// Validator is implemented by pipes that check their params before they are
+// rendered. Pipes without params to check do not implement it.
+type Validator interface {
+	Validate() error
+}
+
+// ParamError is an invalid param of a transform. Index is the position of
+// the transform in its query and is -1 when the pipe is validated on its own.
+type ParamError struct {
+	Index   int    `json:"index"`
+	Fn      string `json:"fn,omitempty"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *ParamError) Error() string {
+	var prefix []string
+	if e.Index >= 0 {
+		prefix = append(prefix, fmt.Sprintf("transforms[%d]", e.Index))
+	}
+	if e.Fn != "" {
+		prefix = append(prefix, e.Fn)
+	}
+	if e.Param != "" {
+		prefix = append(prefix, e.Param)
+	}
+	prefix = append(prefix, e.Message)
+	return strings.Join(prefix, ": ")
+}
+
+// ParamErrors is the result of a failed validation. It lists every invalid
+// param, not only the first one.
+type ParamErrors []*ParamError
+
+func (e ParamErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, p := range e {
+		messages = append(messages, p.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (e *ParamErrors) add(param, format string, args ...interface{}) {
+	*e = append(*e, &ParamError{Index: -1, Param: param, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ParamErrors) positive(param string, n int) {
+	if n <= 0 {
+		e.add(param, "must be greater than 0")
+	}
+}
+
+func (e *ParamErrors) duration(param string, d *Duration) {
+	if d == nil {
+		return
+	}
+	if err := d.Error(); err != nil {
+		e.add(param, "%s", err)
+	}
+}
+
+func (e *ParamErrors) enum(param string, value string, values []string) {
+	for _, v := range values {
+		if v == value {
+			return
+		}
+	}
+	e.add(param, "must be one of %s", strings.Join(values, ", "))
+}
+
+func (e *ParamErrors) fn(fn string) {
+	if strings.TrimSpace(fn) == "" {
+		e.add("fn", "is required")
+	}
+}
+
+func (e ParamErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// ValidatePipes validates each pipe of a query and addresses the errors by
+// the index and the fn of the pipe.
+func ValidatePipes(transforms []TransformPipe) error {
+	var errs ParamErrors
+	for i, t := range transforms {
+		v, ok := t.(Validator)
+		if !ok || t == nil {
+			continue
+		}
+		err := v.Validate()
+		if err == nil {
+			continue
+		}
+		fn := NameOf(t)
+		var params ParamErrors
+		var param *ParamError
+		switch {
+		case errors.As(err, &params):
+		case errors.As(err, &param):
+			params = ParamErrors{param}
+		default:
+			params = ParamErrors{{Message: err.Error()}}
+		}
+		for _, p := range params {
+			p.Index = i
+			p.Fn = fn
+			errs = append(errs, p)
+		}
+	}
+	return errs.err()
+}
+
+func (a *AggregatorPipe) Validate() error {
+	var errs ParamErrors
+	// fn is a function reference, it cannot be quoted
+	if err := literal.Identifier(string(a.Fn)); err != nil {
+		errs.add("fn", "%s", err)
+	}
+	errs.duration("every", &a.Every)
+	errs.duration("period", a.Period)
+	errs.duration("offset", a.Offset)
+	return errs.err()
+}
+
+func (a *BottomPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *TopPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *DerivativePipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("unit", a.Unit)
+	return errs.err()
+}
+
+func (a *DoubleEMAPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *ElapsedPipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("unit", a.Unit)
+	return errs.err()
+}
+
+func (a *ExponentialMovingAveragePipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *FilterPipe) Validate() error {
+	var errs ParamErrors
+	if a.Lambda == nil {
+		errs.fn(a.Fn)
+	}
+	return errs.err()
+}
+
+func (a *FillPipe) Validate() error {
+	var errs ParamErrors
+	if a.UsePrevious == nil || !*a.UsePrevious {
+		if a.Value == nil {
+			errs.add("value", "is required unless usePrevious is set")
+		} else if _, err := literal.Value(a.Value); err != nil {
+			errs.add("value", "%s", err)
+		}
+	}
+	return errs.err()
+}
+
+func (a *GroupPipe) Validate() error {
+	var errs ParamErrors
+	if a.Mode != nil {
+		errs.enum("mode", *a.Mode, []string{"by", "except"})
+	}
+	return errs.err()
+}
+
+func (a *IntegralPipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("unit", &a.Unit)
+	if a.Interpolate != nil {
+		errs.enum("interpolate", *a.Interpolate, []string{"", "linear"})
+	}
+	return errs.err()
+}
+
+func (a *KaufmansAMAPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *KaufmansERPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *LimitPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	if a.Offset != nil && *a.Offset < 0 {
+		errs.add("offset", "must not be negative")
+	}
+	return errs.err()
+}
+
+func (a *MapPipe) Validate() error {
+	var errs ParamErrors
+	if len(a.Fields) == 0 {
+		errs.add("fields", "at least one field is required")
+	}
+	for i, f := range a.Fields {
+		if f.Column == "" {
+			errs.add(fmt.Sprintf("fields[%d].column", i), "is required")
+		}
+		if f.Value == nil {
+			errs.add(fmt.Sprintf("fields[%d].value", i), "is required")
+		}
+	}
+	return errs.err()
+}
+
+func (a *MedianPipe) Validate() error {
+	var errs ParamErrors
+	if a.Method != nil {
+		errs.enum("method", string(*a.Method), a.Method.Values())
+	}
+	if a.Compression != nil && *a.Compression <= 0 {
+		errs.add("compression", "must be greater than 0")
+	}
+	return errs.err()
+}
+
+func (a *MovingAveragePipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *PivotPipe) Validate() error {
+	var errs ParamErrors
+	if len(a.RowKey) == 0 {
+		errs.add("rowKey", "at least one row key is required")
+	}
+	if len(a.ColumnKey) == 0 {
+		errs.add("columnKey", "at least one column key is required")
+	}
+	if a.ValueColumn == "" {
+		errs.add("valueColumn", "is required")
+	}
+	return errs.err()
+}
+
+func (a *QuantilePipe) Validate() error {
+	var errs ParamErrors
+	if a.Q < 0 || a.Q > 1 {
+		errs.add("q", "must be between 0 and 1")
+	}
+	if a.Method != nil {
+		errs.enum("method", string(*a.Method), a.Method.Values())
+	}
+	if a.Compression != nil && *a.Compression <= 0 {
+		errs.add("compression", "must be greater than 0")
+	}
+	return errs.err()
+}
+
+func (a *RelativeStrengthIndexPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *StateCountPipe) Validate() error {
+	var errs ParamErrors
+	if a.Lambda == nil {
+		errs.fn(a.Fn)
+	}
+	return errs.err()
+}
+
+func (a *StateDurationPipe) Validate() error {
+	var errs ParamErrors
+	if a.Lambda == nil {
+		errs.fn(a.Fn)
+	}
+	errs.duration("unit", a.Unit)
+	return errs.err()
+}
+
+func (a *StateTrackingPipe) Validate() error {
+	var errs ParamErrors
+	if a.Lambda == nil {
+		errs.fn(a.Fn)
+	}
+	errs.duration("durationUnit", a.DurationUnit)
+	return errs.err()
+}
+
+func (a *StddevPipe) Validate() error {
+	var errs ParamErrors
+	if a.Mode != nil {
+		errs.enum("mode", string(*a.Mode), a.Mode.Values())
+	}
+	return errs.err()
+}
+
+func (a *TailPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	if a.Offset != nil && *a.Offset < 0 {
+		errs.add("offset", "must not be negative")
+	}
+	return errs.err()
+}
+
+func (a *TimeMovingAveragePipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("every", &a.Every)
+	errs.duration("period", &a.Period)
+	return errs.err()
+}
+
+func (a *TimeShiftPipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("duration", &a.Duration)
+	return errs.err()
+}
+
+func (a *KeepPipe) Validate() error {
+	var errs ParamErrors
+	if len(a.Columns) == 0 {
+		errs.add("columns", "at least one column is required")
+	}
+	return errs.err()
+}
+
+func (a *DropPipe) Validate() error {
+	var errs ParamErrors
+	if len(a.Columns) == 0 {
+		errs.add("columns", "at least one column is required")
+	}
+	return errs.err()
+}
+
+func (a *TimeWeightedAvgPipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("unit", &a.Unit)
+	return errs.err()
+}
+
+func (a *TripleEMAPipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *TripleExponentialDerivativePipe) Validate() error {
+	var errs ParamErrors
+	errs.positive("n", a.N)
+	return errs.err()
+}
+
+func (a *TruncateTimeColumnPipe) Validate() error {
+	var errs ParamErrors
+	errs.duration("unit", &a.Unit)
+	return errs.err()
+}
+
+func (a *WindowPipe) Validate() error {
+	var errs ParamErrors
+	if a.Every == nil && a.Period == nil {
+		errs.add("every", "one of every or period is required")
+	}
+	errs.duration("every", a.Every)
+	errs.duration("period", a.Period)
+	errs.duration("offset", a.Offset)
+	return errs.err()
+}